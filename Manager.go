@@ -11,6 +11,7 @@ import (
 
 func Initial() (err error) {
 	syncModels()
+	controller.ReserveRoutes()
 
 	var modules []*model.Module
 	err = database.DB.Find(&modules, &model.Module{Status: 1}).Error
@@ -19,9 +20,14 @@ func Initial() (err error) {
 		return
 	}
 
+	// 结束已禁用或已删除模块遗留的插件进程
+	manager.SweepReattach(modules)
+
 	// 已注册模块进行加载
 	for _, module := range modules {
-		manager.RegisterModule(module)
+		if err := manager.RegisterModule(module); err != nil {
+			logger.Errorln("模块【", module.Name, "】加载失败, 忽略该模块", err)
+		}
 	}
 
 	// 所有模块加载完毕
@@ -44,5 +50,7 @@ func syncModels() {
 		model.ModuleDependency{},
 		model.ModuleInjectInfo{},
 		model.ModuleSettings{},
+		model.ModuleHeaderPolicy{},
+		model.ModuleReattach{},
 	)
 }
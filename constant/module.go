@@ -0,0 +1,53 @@
+package constant
+
+// 模块类型
+const (
+	ModuleKindPlugin = "plugin" // go-plugin插件(默认)
+	ModuleKindHttp   = "http"   // 普通HTTP服务, 主程序反向代理注入点请求
+	ModuleKindRemote = "remote" // 远程模块, 通过gRPC连接已运行的模块服务
+)
+
+// 模块进程池负载均衡策略
+const (
+	BalanceRoundRobin    = "round_robin"     // 轮询
+	BalanceLeastInFlight = "least_in_flight" // 最少调用中
+)
+
+// 可重试的调用错误类型
+const (
+	RetryOnUnavailable = "unavailable" // 模块进程不可用或连接中断, 如模块重启中
+	RetryOnTimeout     = "timeout"     // 调用超时
+	RetryOnBusy        = "busy"        // 调用数已达上限
+)
+
+// 响应缓存区分维度
+const (
+	CacheVaryNone   = ""       // 所有用户共用, 需要权限的注入点按用户区分
+	CacheVaryUser   = "user"   // 按用户区分
+	CacheVaryTenant = "tenant" // 按租户区分
+)
+
+// 限流维度
+const (
+	RateKeyIP     = "ip"     // 按客户端IP
+	RateKeyUser   = "user"   // 按用户, 未登录时按客户端IP
+	RateKeyTenant = "tenant" // 按租户, 无租户时按客户端IP
+)
+
+// 注入类型
+const (
+	InjectTypeJsonGet    = 1
+	InjectTypeJsonPost   = 2
+	InjectTypeJsonPut    = 3
+	InjectTypeJsonDelete = 4
+	InjectTypeHtmlGet    = 11
+	InjectTypeHtmlPost   = 12
+	InjectTypeHtmlPut    = 13
+	InjectTypeHtmlDelete = 14
+	InjectTypeUpload     = 21
+	InjectTypeStream     = 31
+	InjectTypeSSE        = 32
+	InjectTypeWebSocket  = 33
+	InjectTypeStatic     = 41
+	InjectTypeHook       = 51
+)
@@ -1 +1,10 @@
 package constant
+
+const (
+	// RedisKeyResponseCache 注入点响应缓存, 完整key为 前缀+模块名称:注入点代码:请求摘要
+	RedisKeyResponseCache = "ruomu:module:cache:"
+	// RedisKeyRateLimit 注入点限流令牌桶, 完整key为 前缀+模块名称:注入点代码:维度:标识
+	RedisKeyRateLimit = "ruomu:module:rate:"
+	// RedisKeyRateLimitStat 注入点限流统计, 完整key为 前缀+模块名称:注入点代码
+	RedisKeyRateLimitStat = "ruomu:module:rate-stat:"
+)
@@ -0,0 +1,21 @@
+package constant
+
+// 模块调用相关的响应码, 与ruomu-core中的通用响应码区分
+const (
+	ResponseCodeModuleBusy = 10001
+	ResponseMsgModuleBusy  = "模块繁忙，请稍后重试"
+
+	ResponseCodeModuleTimeout = 10002
+	ResponseMsgModuleTimeout  = "模块处理超时"
+
+	ResponseCodeModuleUnavailable = 10003
+	ResponseMsgModuleUnavailable  = "模块暂不可用"
+
+	ResponseCodeRouteConflict = 10004
+
+	ResponseCodeRateLimited = 10005
+	ResponseMsgRateLimited  = "请求过于频繁，请稍后重试"
+
+	ResponseCodeStreamUnsupported = 10006
+	ResponseMsgStreamUnsupported  = "模块未提供流式调用"
+)
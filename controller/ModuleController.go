@@ -1,12 +1,15 @@
 package controller
 
 import (
+	"errors"
+	"fmt"
 	"github.com/gofiber/fiber/v2"
 	logger "github.com/sirupsen/logrus"
 	"github.com/yockii/ruomu-core/config"
 	"github.com/yockii/ruomu-core/server"
 	"strconv"
 
+	"github.com/yockii/ruomu-module/constant"
 	"github.com/yockii/ruomu-module/manager"
 	"github.com/yockii/ruomu-module/model"
 	"github.com/yockii/ruomu-module/service"
@@ -32,6 +35,84 @@ func (c *moduleController) AddModule(ctx *fiber.Ctx) error {
 			Msg:  server.ResponseMsgParamParseError,
 		})
 	}
+	switch req.Kind {
+	case "", constant.ModuleKindPlugin:
+	case constant.ModuleKindHttp:
+		if req.ProxyTarget == "" {
+			return ctx.JSON(&server.CommonResponse{
+				Code: server.ResponseCodeParamParseError,
+				Msg:  "HTTP服务类模块需要设置服务地址",
+			})
+		}
+	case constant.ModuleKindRemote:
+		if req.RemoteAddr == "" {
+			return ctx.JSON(&server.CommonResponse{
+				Code: server.ResponseCodeParamParseError,
+				Msg:  "远程模块需要设置模块地址",
+			})
+		}
+		if req.RemoteInsecure && !manager.AllowInsecureRemote() {
+			return ctx.JSON(&server.CommonResponse{
+				Code: server.ResponseCodeParamParseError,
+				Msg:  "未允许远程模块使用不加密连接",
+			})
+		}
+	default:
+		return ctx.JSON(&server.CommonResponse{
+			Code: server.ResponseCodeParamParseError,
+			Msg:  fmt.Sprintf("模块类型%s不支持", req.Kind),
+		})
+	}
+	if (req.Kind == constant.ModuleKindHttp || req.Kind == constant.ModuleKindRemote) && !manager.IdentitySecretConfigured() {
+		return ctx.JSON(&server.CommonResponse{
+			Code: server.ResponseCodeParamParseError,
+			Msg:  "远程模块及HTTP服务类模块需配置身份签名密钥module.identitySecret",
+		})
+	}
+	if err := manager.CheckRoutePrefix(&req.Module); err != nil {
+		return ctx.JSON(&server.CommonResponse{
+			Code: server.ResponseCodeParamParseError,
+			Msg:  err.Error(),
+		})
+	}
+	for _, inject := range req.Injects {
+		if !manager.IsValidInjectType(inject.Type) {
+			return ctx.JSON(&server.CommonResponse{
+				Code: server.ResponseCodeParamParseError,
+				Msg:  fmt.Sprintf("注入点%s的类型%d不支持", inject.InjectCode, inject.Type),
+			})
+		}
+		if err := manager.CheckRemoteInject(&req.Module, inject); err != nil {
+			return ctx.JSON(&server.CommonResponse{
+				Code: server.ResponseCodeParamParseError,
+				Msg:  err.Error(),
+			})
+		}
+		if err := manager.CheckStaticInject(&req.Module, inject); err != nil {
+			return ctx.JSON(&server.CommonResponse{
+				Code: server.ResponseCodeParamParseError,
+				Msg:  err.Error(),
+			})
+		}
+		if err := manager.CheckRequestSchema(inject); err != nil {
+			return ctx.JSON(&server.CommonResponse{
+				Code: server.ResponseCodeParamParseError,
+				Msg:  err.Error(),
+			})
+		}
+	}
+	manager.ReserveAppRoutes(ctx.App())
+	conflicts, err := manager.CheckRouteConflicts(&req.Module, req.Injects)
+	if err != nil {
+		logger.Errorln(err)
+		return ctx.JSON(&server.CommonResponse{
+			Code: server.ResponseCodeDatabase,
+			Msg:  server.ResponseMsgDatabase + err.Error(),
+		})
+	}
+	if len(conflicts) > 0 {
+		return routeConflicts(ctx, conflicts)
+	}
 	var settings []*model.ModuleSettings
 	if req.NeedDb {
 		for k, v := range config.GetStringMapString("database") {
@@ -48,7 +129,12 @@ func (c *moduleController) AddModule(ctx *fiber.Ctx) error {
 		})
 	}
 	module := &req.Module
-	err := service.ModuleService.AddModule(module, req.Dependencies, req.Injects, settings)
+	// 先以禁用状态保存, 模块加载成功后再启用
+	enable := module.Status == 1
+	if enable {
+		module.Status = -1
+	}
+	err = service.ModuleService.AddModule(module, req.Dependencies, req.Injects, settings)
 	if err != nil {
 		return ctx.JSON(&server.CommonResponse{
 			Code: server.ResponseCodeUnknownError,
@@ -56,8 +142,19 @@ func (c *moduleController) AddModule(ctx *fiber.Ctx) error {
 		})
 	}
 
-	if req.Status == 1 {
-		manager.RegisterModule(module)
+	if enable {
+		if err = manager.RegisterModule(module); err != nil {
+			logger.Errorln("模块【", module.Name, "】加载失败", err)
+			return registerFailed(ctx, err)
+		}
+		if err = service.ModuleService.UpdateStatus(module.ID, 1); err != nil {
+			manager.UnregisterModule(module.Name)
+			return ctx.JSON(&server.CommonResponse{
+				Code: server.ResponseCodeDatabase,
+				Msg:  server.ResponseMsgDatabase + err.Error(),
+			})
+		}
+		module.Status = 1
 		go func() {
 			_ = server.Shutdown() // 重启server
 		}()
@@ -66,6 +163,26 @@ func (c *moduleController) AddModule(ctx *fiber.Ctx) error {
 	return ctx.JSON(&server.CommonResponse{Data: true})
 }
 
+func routeConflicts(ctx *fiber.Ctx, conflicts []*manager.RouteConflict) error {
+	return ctx.JSON(&server.CommonResponse{
+		Code: constant.ResponseCodeRouteConflict,
+		Msg:  conflicts[0].Error(),
+		Data: conflicts,
+	})
+}
+
+// registerFailed 模块加载失败的响应, 路由冲突时返回冲突信息
+func registerFailed(ctx *fiber.Ctx, err error) error {
+	var conflictErr *manager.RouteConflictError
+	if errors.As(err, &conflictErr) {
+		return routeConflicts(ctx, conflictErr.Conflicts)
+	}
+	return ctx.JSON(&server.CommonResponse{
+		Code: server.ResponseCodeUnknownError,
+		Msg:  err.Error(),
+	})
+}
+
 // List 获取Module列表
 func (c *moduleController) List(ctx *fiber.Ctx) error {
 	// 获取筛选条件和分页信息
@@ -146,11 +263,38 @@ func (c *moduleController) UpdateStatus(ctx *fiber.Ctx) error {
 			Msg:  server.ResponseMsgDatabase + err.Error(),
 		})
 	}
+	if module == nil {
+		return ctx.JSON(&server.CommonResponse{
+			Code: server.ResponseCodeModuleNotExists,
+			Msg:  server.ResponseMsgModuleNotExists,
+		})
+	}
 	// 若原状态与目标状态不一致, 则根据情况处理server和路由
 	if module.Status != instance.Status {
-		// 若目标状态为启用, 则注册module
+		// 若目标状态为启用, 则检查路由冲突并注册module, 加载失败时不更新状态
 		if instance.Status == 1 {
-			manager.RegisterModule(module)
+			injects, err := service.ModuleService.Injects(module.ID)
+			if err != nil {
+				return ctx.JSON(&server.CommonResponse{
+					Code: server.ResponseCodeDatabase,
+					Msg:  server.ResponseMsgDatabase + err.Error(),
+				})
+			}
+			manager.ReserveAppRoutes(ctx.App())
+			conflicts, err := manager.CheckRouteConflicts(module, injects)
+			if err != nil {
+				return ctx.JSON(&server.CommonResponse{
+					Code: server.ResponseCodeDatabase,
+					Msg:  server.ResponseMsgDatabase + err.Error(),
+				})
+			}
+			if len(conflicts) > 0 {
+				return routeConflicts(ctx, conflicts)
+			}
+			if err = manager.RegisterModule(module); err != nil {
+				logger.Errorln("模块【", module.Name, "】加载失败", err)
+				return registerFailed(ctx, err)
+			}
 			go func() {
 				_ = server.Shutdown() // 重启server
 			}()
@@ -172,3 +316,161 @@ func (c *moduleController) UpdateStatus(ctx *fiber.Ctx) error {
 	// 直接返回成功
 	return ctx.JSON(&server.CommonResponse{Data: true})
 }
+
+// OpenAPI 根据已启用模块的注入点生成OpenAPI文档
+func (c *moduleController) OpenAPI(ctx *fiber.Ctx) error {
+	doc, err := manager.BuildOpenAPI()
+	if err != nil {
+		logger.Errorln(err)
+		return ctx.JSON(&server.CommonResponse{
+			Code: server.ResponseCodeDatabase,
+			Msg:  server.ResponseMsgDatabase + err.Error(),
+		})
+	}
+	return ctx.JSON(doc)
+}
+
+// InvalidateCache 清除模块注入点的响应缓存, 未指定注入点代码时清除模块所有注入点的缓存
+func (c *moduleController) InvalidateCache(ctx *fiber.Ctx) error {
+	type invalidateReq struct {
+		ModuleID    uint64   `json:"moduleId,omitempty,string"`
+		InjectCodes []string `json:"injectCodes,omitempty"`
+	}
+	req := new(invalidateReq)
+	if err := ctx.BodyParser(req); err != nil {
+		logger.Errorln(err)
+		return ctx.JSON(&server.CommonResponse{
+			Code: server.ResponseCodeParamParseError,
+			Msg:  server.ResponseMsgParamParseError,
+		})
+	}
+	if req.ModuleID == 0 {
+		return ctx.JSON(&server.CommonResponse{
+			Code: server.ResponseCodeParamParseError,
+			Msg:  server.ResponseMsgParamParseError,
+		})
+	}
+	module, err := service.ModuleService.Instance(req.ModuleID)
+	if err != nil {
+		return ctx.JSON(&server.CommonResponse{
+			Code: server.ResponseCodeDatabase,
+			Msg:  server.ResponseMsgDatabase + err.Error(),
+		})
+	}
+	if module == nil {
+		return ctx.JSON(&server.CommonResponse{
+			Code: server.ResponseCodeModuleNotExists,
+			Msg:  server.ResponseMsgModuleNotExists,
+		})
+	}
+	if err = manager.InvalidateResponseCache(module.Name, req.InjectCodes...); err != nil {
+		logger.Errorln(err)
+		return ctx.JSON(&server.CommonResponse{
+			Code: server.ResponseCodeUnknownError,
+			Msg:  err.Error(),
+		})
+	}
+	return ctx.JSON(&server.CommonResponse{Data: true})
+}
+
+// RateLimit 获取模块各注入点的限流计数
+func (c *moduleController) RateLimit(ctx *fiber.Ctx) error {
+	id, _ := strconv.ParseUint(ctx.Params("id"), 10, 64)
+	module, err := service.ModuleService.Instance(id)
+	if err != nil {
+		logger.Errorln(err)
+		return ctx.JSON(&server.CommonResponse{
+			Code: server.ResponseCodeDatabase,
+			Msg:  server.ResponseMsgDatabase + err.Error(),
+		})
+	}
+	if module == nil {
+		return ctx.JSON(&server.CommonResponse{
+			Code: server.ResponseCodeModuleNotExists,
+			Msg:  server.ResponseMsgModuleNotExists,
+		})
+	}
+	counters, err := manager.RateLimitCounters(module)
+	if err != nil {
+		logger.Errorln(err)
+		return ctx.JSON(&server.CommonResponse{
+			Code: server.ResponseCodeUnknownError,
+			Msg:  err.Error(),
+		})
+	}
+	return ctx.JSON(&server.CommonResponse{Data: counters})
+}
+
+// SaveHeaderPolicy 保存模块或注入点的CORS及安全响应头策略, 已加载的模块立即生效
+func (c *moduleController) SaveHeaderPolicy(ctx *fiber.Ctx) error {
+	policy := new(model.ModuleHeaderPolicy)
+	if err := ctx.BodyParser(policy); err != nil {
+		logger.Errorln(err)
+		return ctx.JSON(&server.CommonResponse{
+			Code: server.ResponseCodeParamParseError,
+			Msg:  server.ResponseMsgParamParseError,
+		})
+	}
+	if policy.ModuleID == 0 {
+		return ctx.JSON(&server.CommonResponse{
+			Code: server.ResponseCodeParamParseError,
+			Msg:  server.ResponseMsgParamParseError,
+		})
+	}
+	module, err := service.ModuleService.Instance(policy.ModuleID)
+	if err != nil {
+		return ctx.JSON(&server.CommonResponse{
+			Code: server.ResponseCodeDatabase,
+			Msg:  server.ResponseMsgDatabase + err.Error(),
+		})
+	}
+	if module == nil {
+		return ctx.JSON(&server.CommonResponse{
+			Code: server.ResponseCodeModuleNotExists,
+			Msg:  server.ResponseMsgModuleNotExists,
+		})
+	}
+	if err = service.ModuleService.SaveHeaderPolicy(policy); err != nil {
+		return ctx.JSON(&server.CommonResponse{
+			Code: server.ResponseCodeDatabase,
+			Msg:  server.ResponseMsgDatabase + err.Error(),
+		})
+	}
+	if err = manager.ReloadHeaderPolicies(module); err != nil {
+		logger.Errorln(err)
+	}
+	return ctx.JSON(&server.CommonResponse{Data: policy})
+}
+
+// DeleteHeaderPolicy 删除响应头策略, 注入点策略删除后使用模块默认策略
+func (c *moduleController) DeleteHeaderPolicy(ctx *fiber.Ctx) error {
+	instance := new(model.ModuleHeaderPolicy)
+	if err := ctx.BodyParser(instance); err != nil {
+		logger.Errorln(err)
+		return ctx.JSON(&server.CommonResponse{
+			Code: server.ResponseCodeParamParseError,
+			Msg:  server.ResponseMsgParamParseError,
+		})
+	}
+	if instance.ID == 0 {
+		return ctx.JSON(&server.CommonResponse{
+			Code: server.ResponseCodeParamParseError,
+			Msg:  server.ResponseMsgParamParseError,
+		})
+	}
+	policy, err := service.ModuleService.DeleteHeaderPolicy(instance.ID)
+	if err != nil {
+		return ctx.JSON(&server.CommonResponse{
+			Code: server.ResponseCodeDatabase,
+			Msg:  server.ResponseMsgDatabase + err.Error(),
+		})
+	}
+	if policy != nil {
+		if module, _ := service.ModuleService.Instance(policy.ModuleID); module != nil {
+			if err = manager.ReloadHeaderPolicies(module); err != nil {
+				logger.Errorln(err)
+			}
+		}
+	}
+	return ctx.JSON(&server.CommonResponse{Data: true})
+}
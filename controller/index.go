@@ -1,14 +1,45 @@
 package controller
 
 import (
+	"github.com/gofiber/fiber/v2"
 	"github.com/yockii/ruomu-core/server"
 	"github.com/yockii/ruomu-module/manager"
 )
 
+const routePrefix = "/module"
+
+type route struct {
+	method            string
+	path              string
+	authorizationCode string
+	handler           fiber.Handler
+}
+
+func routes() []*route {
+	return []*route{
+		{fiber.MethodPost, "/add", "module:add", ModuleController.AddModule},
+		{fiber.MethodGet, "/list", "module:list", ModuleController.List},
+		{fiber.MethodGet, "/detail/:id", "module:detail", ModuleController.Detail},
+		{fiber.MethodPost, "/updateStatus", "module:updateStatus", ModuleController.UpdateStatus},
+		{fiber.MethodGet, "/openapi.json", "module:openapi", ModuleController.OpenAPI},
+		{fiber.MethodPost, "/cache/invalidate", "module:cacheInvalidate", ModuleController.InvalidateCache},
+		{fiber.MethodGet, "/rateLimit/:id", "module:rateLimit", ModuleController.RateLimit},
+		{fiber.MethodPost, "/headerPolicy/save", "module:headerPolicy", ModuleController.SaveHeaderPolicy},
+		{fiber.MethodPost, "/headerPolicy/delete", "module:headerPolicy", ModuleController.DeleteHeaderPolicy},
+	}
+}
+
+// ReserveRoutes 登记模块管理接口, 需在加载模块之前调用, 防止模块注入点与其冲突
+// 主程序的其他路由在添加或启用模块时从server中读取登记(见manager.ReserveAppRoutes)
+func ReserveRoutes() {
+	for _, r := range routes() {
+		manager.ReserveHostRoute(r.method, routePrefix+r.path)
+	}
+}
+
 func InitRouter() {
-	module := server.Group("/module")
-	module.Post("/add", manager.CheckAuthorizationMiddleware("module:add"), ModuleController.AddModule)
-	module.Get("/list", manager.CheckAuthorizationMiddleware("module:list"), ModuleController.List)
-	module.Get("/detail/:id", manager.CheckAuthorizationMiddleware("module:detail"), ModuleController.Detail)
-	module.Post("/updateStatus", manager.CheckAuthorizationMiddleware("module:updateStatus"), ModuleController.UpdateStatus)
+	module := server.Group(routePrefix)
+	for _, r := range routes() {
+		module.Add(r.method, r.path, manager.CheckAuthorizationMiddleware(r.authorizationCode), r.handler)
+	}
 }
@@ -0,0 +1,31 @@
+package domain
+
+// InjectRequest 统一的请求上下文, 所有类型的注入调用均通过请求头X-Ruomu-Request传递(不含请求头及请求体),
+// 注入点开启完整请求上下文时作为调用参数完整传递
+type InjectRequest struct {
+	Method     string              `json:"method"`
+	Path       string              `json:"path"`
+	PathParams map[string]string   `json:"pathParams,omitempty"`
+	Query      map[string][]string `json:"query,omitempty"`
+	Headers    map[string][]string `json:"headers,omitempty"`
+	Body       []byte              `json:"body,omitempty"`
+	ClientIP   string              `json:"clientIp,omitempty"`
+	UserID     string              `json:"userId,omitempty"`
+	TenantID   string              `json:"tenantId,omitempty"`
+	Roles      []string            `json:"roles,omitempty"`
+	RequestID  string              `json:"requestId,omitempty"`
+}
+
+// InjectUpload 上传类注入点的调用参数, 文件已由主程序保存至临时目录, 调用结束后删除
+type InjectUpload struct {
+	Fields map[string][]string `json:"fields,omitempty"`
+	Files  []*UploadFile       `json:"files,omitempty"`
+}
+
+type UploadFile struct {
+	Field       string `json:"field"`
+	FileName    string `json:"fileName"`
+	ContentType string `json:"contentType,omitempty"`
+	Size        int64  `json:"size"`
+	Path        string `json:"path"` // 临时文件路径
+}
@@ -0,0 +1,23 @@
+package domain
+
+// InjectResponse 模块响应包装, 注入点开启包装响应时模块InjectCall返回该结构的JSON,
+// 主程序据此设置HTTP状态码、响应头、Cookie及响应体
+type InjectResponse struct {
+	Status      int               `json:"status,omitempty"`      // HTTP状态码, 为空时200
+	ContentType string            `json:"contentType,omitempty"` // 为空时使用注入类型的默认类型
+	Headers     map[string]string `json:"headers,omitempty"`
+	Cookies     []*InjectCookie   `json:"cookies,omitempty"`
+	Body        []byte            `json:"body,omitempty"`
+}
+
+type InjectCookie struct {
+	Name     string `json:"name"`
+	Value    string `json:"value"`
+	Path     string `json:"path,omitempty"`
+	Domain   string `json:"domain,omitempty"`
+	MaxAge   int    `json:"maxAge,omitempty"`
+	Expires  int64  `json:"expires,omitempty"` // unix秒
+	Secure   bool   `json:"secure,omitempty"`
+	HttpOnly bool   `json:"httpOnly,omitempty"`
+	SameSite string `json:"sameSite,omitempty"`
+}
@@ -4,7 +4,8 @@ import "github.com/yockii/ruomu-module/model"
 
 type Module struct {
 	model.Module
-	Dependencies []*model.ModuleDependency `json:"dependencies,omitempty"`
-	Injects      []*model.ModuleInjectInfo `json:"injects,omitempty"`
-	Settings     []*model.ModuleSettings   `json:"settings,omitempty"`
+	Dependencies   []*model.ModuleDependency   `json:"dependencies,omitempty"`
+	Injects        []*model.ModuleInjectInfo   `json:"injects,omitempty"`
+	Settings       []*model.ModuleSettings     `json:"settings,omitempty"`
+	HeaderPolicies []*model.ModuleHeaderPolicy `json:"headerPolicies,omitempty"`
 }
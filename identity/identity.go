@@ -0,0 +1,132 @@
+package identity
+
+import (
+	"crypto/hmac"
+	"crypto/sha256"
+	"encoding/hex"
+	"errors"
+	"net/textproto"
+	"strconv"
+	"strings"
+	"time"
+)
+
+// 主程序注入的身份请求头, 客户端传入的同名请求头在转发前被移除
+const (
+	HeaderUserId    = "X-Ruomu-User-Id"
+	HeaderTenantId  = "X-Ruomu-Tenant-Id"
+	HeaderRoles     = "X-Ruomu-Roles" // 逗号分隔的角色ID
+	HeaderRequestId = "X-Ruomu-Request-Id"
+	HeaderTimestamp = "X-Ruomu-Timestamp" // 签名时间(毫秒)
+	HeaderSignature = "X-Ruomu-Signature" // HMAC-SHA256签名(十六进制)
+
+	// HeaderPrefix 主程序保留的请求头前缀
+	HeaderPrefix = "X-Ruomu-"
+
+	// ParamSecret 签名密钥在模块初始化参数中的键, 主程序配置中为主密钥, 传递给模块的为该模块的密钥
+	ParamSecret = "module.identitySecret"
+)
+
+var (
+	ErrMissingSignature = errors.New("缺少身份签名")
+	ErrInvalidSignature = errors.New("身份签名不正确")
+	ErrExpired          = errors.New("身份签名已过期")
+)
+
+// Identity 主程序确认的调用方身份
+type Identity struct {
+	UserID    string
+	TenantID  string
+	Roles     []string
+	RequestID string
+	Timestamp int64
+}
+
+func (id *Identity) payload() string {
+	return strings.Join([]string{
+		id.UserID,
+		id.TenantID,
+		strings.Join(id.Roles, ","),
+		id.RequestID,
+		strconv.FormatInt(id.Timestamp, 10),
+	}, "\n")
+}
+
+// ModuleKey 由主密钥派生模块的签名密钥, 每个模块只持有自己的密钥, 无法伪造发往其他模块的身份请求头
+func ModuleKey(master []byte, module string) string {
+	mac := hmac.New(sha256.New, master)
+	mac.Write([]byte("ruomu-module:" + module))
+	return hex.EncodeToString(mac.Sum(nil))
+}
+
+// Sign 计算身份签名
+func Sign(secret []byte, id *Identity) string {
+	mac := hmac.New(sha256.New, secret)
+	mac.Write([]byte(id.payload()))
+	return hex.EncodeToString(mac.Sum(nil))
+}
+
+// Headers 生成带签名的身份请求头, Timestamp为0时使用当前时间
+func Headers(secret []byte, id *Identity) map[string]string {
+	if id.Timestamp == 0 {
+		id.Timestamp = time.Now().UnixMilli()
+	}
+	headers := map[string]string{
+		HeaderRequestId: id.RequestID,
+		HeaderTimestamp: strconv.FormatInt(id.Timestamp, 10),
+		HeaderSignature: Sign(secret, id),
+	}
+	if id.UserID != "" {
+		headers[HeaderUserId] = id.UserID
+	}
+	if id.TenantID != "" {
+		headers[HeaderTenantId] = id.TenantID
+	}
+	if len(id.Roles) > 0 {
+		headers[HeaderRoles] = strings.Join(id.Roles, ",")
+	}
+	return headers
+}
+
+// FromHeaders 从请求头读取身份信息, 不校验签名
+func FromHeaders(headers map[string][]string) *Identity {
+	get := func(name string) string {
+		for k, v := range headers {
+			if len(v) > 0 && textproto.CanonicalMIMEHeaderKey(k) == name {
+				return v[0]
+			}
+		}
+		return ""
+	}
+	id := &Identity{
+		UserID:    get(HeaderUserId),
+		TenantID:  get(HeaderTenantId),
+		RequestID: get(HeaderRequestId),
+	}
+	if roles := get(HeaderRoles); roles != "" {
+		id.Roles = strings.Split(roles, ",")
+	}
+	id.Timestamp, _ = strconv.ParseInt(get(HeaderTimestamp), 10, 64)
+	return id
+}
+
+// Verify 模块校验请求头中的身份签名, maxAge>0时校验签名时间
+func Verify(secret []byte, headers map[string][]string, maxAge time.Duration) (*Identity, error) {
+	var signature string
+	for k, v := range headers {
+		if len(v) > 0 && textproto.CanonicalMIMEHeaderKey(k) == HeaderSignature {
+			signature = v[0]
+		}
+	}
+	if signature == "" {
+		return nil, ErrMissingSignature
+	}
+	id := FromHeaders(headers)
+	if !hmac.Equal([]byte(signature), []byte(Sign(secret, id))) {
+		return nil, ErrInvalidSignature
+	}
+	if maxAge > 0 && time.Since(time.UnixMilli(id.Timestamp)) > maxAge {
+		return nil, ErrExpired
+	}
+	return id, nil
+}
@@ -0,0 +1,200 @@
+package manager
+
+import (
+	"errors"
+	"sync"
+	"time"
+
+	"github.com/sirupsen/logrus"
+
+	"github.com/yockii/ruomu-module/model"
+)
+
+const (
+	BreakerStateClosed   = "closed"
+	BreakerStateOpen     = "open"
+	BreakerStateHalfOpen = "half_open"
+
+	breakerWindow          = 10 * time.Second
+	defaultBreakerMinCalls = 20
+	defaultBreakerOpenTime = 30 * time.Second
+)
+
+var errBreakerOpen = errors.New("模块熔断中, 暂不可用")
+
+// BreakerStateListener 熔断器状态变化监听
+type BreakerStateListener func(moduleName string, from, to string)
+
+var (
+	breakerListenersLock sync.RWMutex
+	breakerListeners     []BreakerStateListener
+)
+
+// OnBreakerStateChange 注册熔断器状态变化监听
+func OnBreakerStateChange(listener BreakerStateListener) {
+	breakerListenersLock.Lock()
+	defer breakerListenersLock.Unlock()
+	breakerListeners = append(breakerListeners, listener)
+}
+
+// circuitBreaker 模块熔断器, 统计窗口内失败率达到阈值后打开, 打开期间直接拒绝调用,
+// 超过打开时长后进入半开状态, 仅放行一个探测调用, 成功则关闭, 失败则重新打开
+type circuitBreaker struct {
+	moduleName string
+	ratio      float64
+	minCalls   int
+	openTime   time.Duration
+
+	mu          sync.Mutex
+	state       string
+	windowStart time.Time
+	calls       int
+	failures    int
+	openedAt    time.Time
+	probing     bool
+	generation  uint64 // 状态变化时递增, 用于识别调用开始时所处的状态
+}
+
+// breakerTicket 允许调用时发放, 记录调用开始时熔断器的状态代数及是否为半开状态的探测调用
+type breakerTicket struct {
+	generation uint64
+	probe      bool
+}
+
+// newCircuitBreaker 模块未配置失败率时不启用, 返回nil
+func newCircuitBreaker(module *model.Module) *circuitBreaker {
+	if module.BreakerRatio <= 0 {
+		return nil
+	}
+	minCalls := module.BreakerMinCalls
+	if minCalls <= 0 {
+		minCalls = defaultBreakerMinCalls
+	}
+	openTime := defaultBreakerOpenTime
+	if module.BreakerOpenTime > 0 {
+		openTime = time.Duration(module.BreakerOpenTime) * time.Millisecond
+	}
+	return &circuitBreaker{
+		moduleName:  module.Name,
+		ratio:       float64(module.BreakerRatio) / 100,
+		minCalls:    minCalls,
+		openTime:    openTime,
+		state:       BreakerStateClosed,
+		windowStart: time.Now(),
+	}
+}
+
+// allow 判断是否允许调用, 允许时调用结束后必须使用返回的ticket调用done
+func (b *circuitBreaker) allow() (breakerTicket, error) {
+	if b == nil {
+		return breakerTicket{}, nil
+	}
+	b.mu.Lock()
+	defer b.mu.Unlock()
+	switch b.state {
+	case BreakerStateOpen:
+		if time.Since(b.openedAt) < b.openTime {
+			return breakerTicket{}, errBreakerOpen
+		}
+		b.setState(BreakerStateHalfOpen)
+		b.probing = true
+		return breakerTicket{generation: b.generation, probe: true}, nil
+	case BreakerStateHalfOpen:
+		if b.probing {
+			return breakerTicket{}, errBreakerOpen
+		}
+		b.probing = true
+		return breakerTicket{generation: b.generation, probe: true}, nil
+	}
+	if time.Since(b.windowStart) > breakerWindow {
+		b.windowStart = time.Now()
+		b.calls, b.failures = 0, 0
+	}
+	return breakerTicket{generation: b.generation}, nil
+}
+
+// done 记录调用结果, 状态变化前开始的调用结果不再计入, 半开状态仅以探测调用的结果决定关闭或重新打开
+func (b *circuitBreaker) done(ticket breakerTicket, success bool) {
+	if b == nil {
+		return
+	}
+	b.mu.Lock()
+	defer b.mu.Unlock()
+	if ticket.generation != b.generation {
+		return
+	}
+	switch b.state {
+	case BreakerStateHalfOpen:
+		if !ticket.probe {
+			return
+		}
+		b.probing = false
+		if success {
+			b.windowStart = time.Now()
+			b.calls, b.failures = 0, 0
+			b.setState(BreakerStateClosed)
+		} else {
+			b.openedAt = time.Now()
+			b.setState(BreakerStateOpen)
+		}
+		return
+	case BreakerStateOpen:
+		return
+	}
+	b.calls++
+	if !success {
+		b.failures++
+	}
+	if b.calls >= b.minCalls && float64(b.failures)/float64(b.calls) >= b.ratio {
+		b.openedAt = time.Now()
+		b.setState(BreakerStateOpen)
+	}
+}
+
+// release 调用未到达模块(并发限制、调用取消等), 不计入结果, 半开状态下仅释放探测许可, 不改变状态
+func (b *circuitBreaker) release(ticket breakerTicket) {
+	if b == nil {
+		return
+	}
+	b.mu.Lock()
+	defer b.mu.Unlock()
+	if ticket.probe && ticket.generation == b.generation && b.state == BreakerStateHalfOpen {
+		b.probing = false
+	}
+}
+
+// finish 按调用错误记录结果, 未到达模块的错误不计入
+func (b *circuitBreaker) finish(ticket breakerTicket, err error) {
+	if err != nil && !isBreakerFailure(err) {
+		b.release(ticket)
+		return
+	}
+	b.done(ticket, err == nil)
+}
+
+// setState 需持有锁调用
+func (b *circuitBreaker) setState(state string) {
+	if b.state == state {
+		return
+	}
+	from := b.state
+	b.state = state
+	b.generation++
+	logrus.Warnln("模块【", b.moduleName, "】熔断器状态变化:", from, "->", state)
+
+	breakerListenersLock.RLock()
+	listeners := append([]BreakerStateListener(nil), breakerListeners...)
+	breakerListenersLock.RUnlock()
+	for _, listener := range listeners {
+		go listener(b.moduleName, from, state)
+	}
+}
+
+// isBreakerFailure 并发限制、调用取消及模块不支持的调用方式不计入模块的成功或失败
+func isBreakerFailure(err error) bool {
+	if err == nil {
+		return false
+	}
+	return !errors.Is(err, errBulkheadFull) && !errors.Is(err, errBulkheadTimeout) &&
+		!errors.Is(err, errCallCanceled) && !errors.Is(err, errStreamUnsupported)
+}
@@ -0,0 +1,96 @@
+package manager
+
+import (
+	"testing"
+	"time"
+
+	"github.com/yockii/ruomu-module/model"
+)
+
+func TestCircuitBreakerStates(t *testing.T) {
+	newOpenBreaker := func() *circuitBreaker {
+		b := newCircuitBreaker(&model.Module{Name: "test", BreakerRatio: 50, BreakerMinCalls: 2, BreakerOpenTime: 1000})
+		for i := 0; i < 2; i++ {
+			tk, _ := b.allow()
+			b.done(tk, false)
+		}
+		// 跳过打开时长, 下一次allow进入半开状态
+		b.openedAt = time.Now().Add(-2 * time.Second)
+		return b
+	}
+
+	tests := []struct {
+		name string
+		run  func(t *testing.T, b *circuitBreaker)
+		want string
+	}{
+		{
+			name: "失败率达到阈值后打开",
+			run:  func(t *testing.T, b *circuitBreaker) {},
+			want: BreakerStateOpen,
+		},
+		{
+			name: "探测成功后关闭",
+			run: func(t *testing.T, b *circuitBreaker) {
+				tk, _ := b.allow()
+				b.finish(tk, nil)
+			},
+			want: BreakerStateClosed,
+		},
+		{
+			name: "探测失败后重新打开",
+			run: func(t *testing.T, b *circuitBreaker) {
+				tk, _ := b.allow()
+				b.finish(tk, errCallTimeout)
+			},
+			want: BreakerStateOpen,
+		},
+		{
+			name: "探测未到达模块时保持半开",
+			run: func(t *testing.T, b *circuitBreaker) {
+				for _, err := range []error{errBulkheadFull, errBulkheadTimeout, errCallCanceled, errStreamUnsupported} {
+					tk, err2 := b.allow()
+					if err2 != nil {
+						t.Fatalf("释放探测许可后应允许新的探测: %v", err2)
+					}
+					b.finish(tk, err)
+				}
+			},
+			want: BreakerStateHalfOpen,
+		},
+		{
+			name: "半开时只放行一个探测",
+			run: func(t *testing.T, b *circuitBreaker) {
+				if _, err := b.allow(); err != nil {
+					t.Fatal(err)
+				}
+				if _, err := b.allow(); err != errBreakerOpen {
+					t.Fatalf("第二个探测应被拒绝, got %v", err)
+				}
+			},
+			want: BreakerStateHalfOpen,
+		},
+		{
+			name: "打开前开始的调用结果不影响半开状态",
+			run: func(t *testing.T, b *circuitBreaker) {
+				stale := breakerTicket{generation: b.generation - 1}
+				tk, _ := b.allow()
+				b.done(stale, true)
+				if !b.probing {
+					t.Fatal("旧调用不应清除探测标记")
+				}
+				b.done(tk, false)
+			},
+			want: BreakerStateOpen,
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			b := newOpenBreaker()
+			tt.run(t, b)
+			if b.state != tt.want {
+				t.Fatalf("state = %s, want %s", b.state, tt.want)
+			}
+		})
+	}
+}
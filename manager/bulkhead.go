@@ -0,0 +1,74 @@
+package manager
+
+import (
+	"errors"
+	"time"
+)
+
+const defaultQueueWait = 5 * time.Second
+
+var (
+	errBulkheadFull    = errors.New("调用数已达上限, 等待队列已满")
+	errBulkheadTimeout = errors.New("等待调用超时")
+)
+
+// bulkhead 隔离舱, 限制同时调用数并提供有限的等待队列, 防止单个模块占满主程序的处理能力
+type bulkhead struct {
+	slots chan struct{}
+	queue chan struct{}
+	wait  time.Duration
+}
+
+// newBulkhead maxConcurrent<=0 时不限制, 返回nil
+func newBulkhead(maxConcurrent, maxQueue, queueWaitMs int) *bulkhead {
+	if maxConcurrent <= 0 {
+		return nil
+	}
+	if maxQueue < 0 {
+		maxQueue = 0
+	}
+	wait := defaultQueueWait
+	if queueWaitMs > 0 {
+		wait = time.Duration(queueWaitMs) * time.Millisecond
+	}
+	return &bulkhead{
+		slots: make(chan struct{}, maxConcurrent),
+		queue: make(chan struct{}, maxQueue),
+		wait:  wait,
+	}
+}
+
+// acquire 获取调用许可, 成功后必须调用release
+func (b *bulkhead) acquire() error {
+	if b == nil {
+		return nil
+	}
+	select {
+	case b.slots <- struct{}{}:
+		return nil
+	default:
+	}
+
+	select {
+	case b.queue <- struct{}{}:
+	default:
+		return errBulkheadFull
+	}
+	defer func() { <-b.queue }()
+
+	timer := time.NewTimer(b.wait)
+	defer timer.Stop()
+	select {
+	case b.slots <- struct{}{}:
+		return nil
+	case <-timer.C:
+		return errBulkheadTimeout
+	}
+}
+
+func (b *bulkhead) release() {
+	if b == nil {
+		return
+	}
+	<-b.slots
+}
@@ -0,0 +1,337 @@
+package manager
+
+import (
+	"container/list"
+	"crypto/sha1"
+	"encoding/hex"
+	"encoding/json"
+	"net/textproto"
+	"net/url"
+	"sort"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/gofiber/fiber/v2"
+	"github.com/gomodule/redigo/redis"
+	"github.com/sirupsen/logrus"
+	"github.com/yockii/ruomu-core/cache"
+	"github.com/yockii/ruomu-core/config"
+	"github.com/yockii/ruomu-core/shared"
+
+	"github.com/yockii/ruomu-module/constant"
+	"github.com/yockii/ruomu-module/domain"
+	"github.com/yockii/ruomu-module/model"
+)
+
+const (
+	// HeaderCacheInvalidate 模块在包装响应中通过该响应头通知数据已变更, 值为逗号分隔的注入点代码, *表示模块的所有注入点
+	HeaderCacheInvalidate = "X-Ruomu-Cache-Invalidate"
+	// HeaderCacheStatus 响应是否来自缓存 HIT/MISS
+	HeaderCacheStatus = "X-Cache"
+
+	defaultResponseCacheSize = 1000
+)
+
+// cachedResponse 缓存的模块返回结果
+type cachedResponse struct {
+	ContentType string `json:"contentType"`
+	Result      []byte `json:"result"`
+}
+
+// responseCache 响应缓存存储
+type responseCache interface {
+	get(key string) (*cachedResponse, bool)
+	set(key string, value *cachedResponse, ttl time.Duration)
+	// removePrefix 删除指定前缀的所有缓存
+	removePrefix(prefix string) error
+}
+
+var (
+	responseCacheOnce     sync.Once
+	responseCacheInstance responseCache
+)
+
+// getResponseCache 根据配置module.responseCache选择缓存存储, redis-使用Redis 其他-进程内LRU(容量module.responseCacheSize)
+func getResponseCache() responseCache {
+	responseCacheOnce.Do(func() {
+		if strings.ToLower(config.GetString("module.responseCache")) == "redis" {
+			responseCacheInstance = new(redisResponseCache)
+			return
+		}
+		size := config.GetInt("module.responseCacheSize")
+		if size <= 0 {
+			size = defaultResponseCacheSize
+		}
+		responseCacheInstance = newMemoryResponseCache(size)
+	})
+	return responseCacheInstance
+}
+
+// cacheKeyPrefix 模块注入点的缓存key前缀, injectCode为空时为模块所有注入点的前缀
+func cacheKeyPrefix(moduleName, injectCode string) string {
+	prefix := constant.RedisKeyResponseCache + moduleName + ":"
+	if injectCode != "" {
+		prefix += injectCode + ":"
+	}
+	return prefix
+}
+
+// newResponseCacheTTL 仅JSON/HTML GET类注入点支持响应缓存
+func newResponseCacheTTL(inject *model.ModuleInjectInfo) time.Duration {
+	if inject.ResponseCacheTTL <= 0 || (inject.Type != constant.InjectTypeJsonGet && inject.Type != constant.InjectTypeHtmlGet) {
+		return 0
+	}
+	return time.Duration(inject.ResponseCacheTTL) * time.Second
+}
+
+// cacheKey 根据路径、排序后的查询参数及区分维度生成缓存key
+func (rt *injectRuntime) cacheKey(ctx *fiber.Ctx) string {
+	args := sortedQuery(ctx)
+	var vary string
+	switch rt.cacheVary() {
+	case constant.CacheVaryUser:
+		vary = localString(ctx, shared.JwtClaimUserId)
+	case constant.CacheVaryTenant:
+		vary = localString(ctx, shared.JwtClaimTenantId)
+	}
+	sum := sha1.Sum([]byte(ctx.Path() + "?" + args + "#" + vary))
+	return cacheKeyPrefix(rt.moduleName, rt.inject.InjectCode) + hex.EncodeToString(sum[:])
+}
+
+// cacheVary 缓存区分维度, 需要权限的注入点未设置时按用户区分, 避免不同用户共用响应
+func (rt *injectRuntime) cacheVary() string {
+	if rt.inject.ResponseCacheVary == constant.CacheVaryNone && requiresAuthorization(rt.inject.AuthorizationCode) {
+		return constant.CacheVaryUser
+	}
+	return rt.inject.ResponseCacheVary
+}
+
+// sortedQuery 按参数名排序的查询参数, 参数顺序不同的请求共用缓存
+func sortedQuery(ctx *fiber.Ctx) string {
+	var pairs []string
+	ctx.Context().QueryArgs().VisitAll(func(key, value []byte) {
+		pairs = append(pairs, url.QueryEscape(string(key))+"="+url.QueryEscape(string(value)))
+	})
+	sort.Strings(pairs)
+	return strings.Join(pairs, "&")
+}
+
+// cachedResult 读取缓存的模块返回结果, 未开启缓存或未命中时返回false
+func (rt *injectRuntime) cachedResult(ctx *fiber.Ctx) (*cachedResponse, bool) {
+	if rt.cacheTTL <= 0 {
+		return nil, false
+	}
+	cached, ok := getResponseCache().get(rt.cacheKey(ctx))
+	if ok {
+		ctx.Set(HeaderCacheStatus, "HIT")
+	} else {
+		ctx.Set(HeaderCacheStatus, "MISS")
+	}
+	return cached, ok
+}
+
+// cacheResult 缓存模块返回结果, 包装响应中状态码非2xx或设置了Cookie时不缓存
+func (rt *injectRuntime) cacheResult(ctx *fiber.Ctx, result []byte, contentType string) {
+	if rt.cacheTTL <= 0 {
+		return
+	}
+	if rt.inject.Envelope {
+		resp := new(domain.InjectResponse)
+		if err := json.Unmarshal(result, resp); err != nil {
+			return
+		}
+		if resp.Status >= fiber.StatusMultipleChoices || len(resp.Cookies) > 0 {
+			return
+		}
+		for name := range resp.Headers {
+			switch textproto.CanonicalMIMEHeaderKey(name) {
+			case HeaderCacheInvalidate, fiber.HeaderSetCookie:
+				return
+			}
+		}
+	}
+	getResponseCache().set(rt.cacheKey(ctx), &cachedResponse{
+		ContentType: contentType,
+		Result:      result,
+	}, rt.cacheTTL)
+}
+
+// InvalidateResponseCache 清除模块注入点的响应缓存, 未指定注入点代码时清除模块所有注入点的缓存
+func InvalidateResponseCache(moduleName string, injectCodes ...string) error {
+	if len(injectCodes) == 0 {
+		return getResponseCache().removePrefix(cacheKeyPrefix(moduleName, ""))
+	}
+	for _, code := range injectCodes {
+		if err := getResponseCache().removePrefix(cacheKeyPrefix(moduleName, code)); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// invalidateBySignal 处理模块通过HeaderCacheInvalidate发出的缓存失效通知
+func invalidateBySignal(moduleName, value string) {
+	var codes []string
+	for _, code := range strings.Split(value, ",") {
+		code = strings.TrimSpace(code)
+		if code == "*" {
+			codes = nil
+			break
+		}
+		if code != "" {
+			codes = append(codes, code)
+		}
+	}
+	if err := InvalidateResponseCache(moduleName, codes...); err != nil {
+		logrus.Errorln("模块【", moduleName, "】响应缓存清除失败", err)
+	}
+}
+
+// memoryResponseCache 进程内LRU缓存
+type memoryResponseCache struct {
+	size  int
+	mu    sync.Mutex
+	ll    *list.List
+	items map[string]*list.Element
+}
+
+type memoryCacheEntry struct {
+	key      string
+	value    *cachedResponse
+	expireAt time.Time
+}
+
+func newMemoryResponseCache(size int) *memoryResponseCache {
+	return &memoryResponseCache{
+		size:  size,
+		ll:    list.New(),
+		items: make(map[string]*list.Element),
+	}
+}
+
+func (c *memoryResponseCache) get(key string) (*cachedResponse, bool) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	e, has := c.items[key]
+	if !has {
+		return nil, false
+	}
+	entry := e.Value.(*memoryCacheEntry)
+	if time.Now().After(entry.expireAt) {
+		c.ll.Remove(e)
+		delete(c.items, key)
+		return nil, false
+	}
+	c.ll.MoveToFront(e)
+	return entry.value, true
+}
+
+func (c *memoryResponseCache) set(key string, value *cachedResponse, ttl time.Duration) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	if e, has := c.items[key]; has {
+		entry := e.Value.(*memoryCacheEntry)
+		entry.value = value
+		entry.expireAt = time.Now().Add(ttl)
+		c.ll.MoveToFront(e)
+		return
+	}
+	c.items[key] = c.ll.PushFront(&memoryCacheEntry{key: key, value: value, expireAt: time.Now().Add(ttl)})
+	for c.ll.Len() > c.size {
+		oldest := c.ll.Back()
+		c.ll.Remove(oldest)
+		delete(c.items, oldest.Value.(*memoryCacheEntry).key)
+	}
+}
+
+func (c *memoryResponseCache) removePrefix(prefix string) error {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	for key, e := range c.items {
+		if strings.HasPrefix(key, prefix) {
+			c.ll.Remove(e)
+			delete(c.items, key)
+		}
+	}
+	return nil
+}
+
+// redisResponseCache 使用ruomu-core的Redis连接池, 多实例部署时共享缓存
+type redisResponseCache struct{}
+
+func (c *redisResponseCache) get(key string) (*cachedResponse, bool) {
+	conn := cache.Get()
+	defer func() {
+		_ = conn.Close()
+	}()
+	bs, err := redis.Bytes(conn.Do("GET", key))
+	if err != nil {
+		if err != redis.ErrNil {
+			logrus.Errorln(err)
+		}
+		return nil, false
+	}
+	value := new(cachedResponse)
+	if err = json.Unmarshal(bs, value); err != nil {
+		return nil, false
+	}
+	return value, true
+}
+
+func (c *redisResponseCache) set(key string, value *cachedResponse, ttl time.Duration) {
+	bs, err := json.Marshal(value)
+	if err != nil {
+		return
+	}
+	conn := cache.Get()
+	defer func() {
+		_ = conn.Close()
+	}()
+	if _, err = conn.Do("SET", key, bs, "PX", ttl.Milliseconds()); err != nil {
+		logrus.Errorln(err)
+	}
+}
+
+func (c *redisResponseCache) removePrefix(prefix string) error {
+	conn := cache.Get()
+	defer func() {
+		_ = conn.Close()
+	}()
+	cursor := 0
+	for {
+		values, err := redis.Values(conn.Do("SCAN", cursor, "MATCH", escapeRedisPattern(prefix)+"*", "COUNT", 100))
+		if err != nil {
+			return err
+		}
+		if cursor, err = redis.Int(values[0], nil); err != nil {
+			return err
+		}
+		keys, err := redis.Strings(values[1], nil)
+		if err != nil {
+			return err
+		}
+		if len(keys) > 0 {
+			args := redis.Args{}.AddFlat(keys)
+			if _, err = conn.Do("DEL", args...); err != nil {
+				return err
+			}
+		}
+		if cursor == 0 {
+			return nil
+		}
+	}
+}
+
+// escapeRedisPattern 转义注入点代码中的匹配符
+func escapeRedisPattern(s string) string {
+	var b strings.Builder
+	for _, r := range s {
+		switch r {
+		case '*', '?', '[', ']', '\\':
+			b.WriteRune('\\')
+		}
+		b.WriteRune(r)
+	}
+	return b.String()
+}
@@ -0,0 +1,217 @@
+package manager
+
+import (
+	"context"
+	"errors"
+	"strconv"
+	"time"
+
+	"github.com/gofiber/fiber/v2"
+	"github.com/santhosh-tekuri/jsonschema/v5"
+	"github.com/yockii/ruomu-core/server"
+	"github.com/yockii/ruomu-core/shared"
+
+	"github.com/yockii/ruomu-module/constant"
+	"github.com/yockii/ruomu-module/model"
+)
+
+const (
+	defaultCallTimeout = 30 * time.Second
+	// HeaderCallDeadline 传递给模块的调用截止时间(unix毫秒), 模块可据此提前放弃处理
+	HeaderCallDeadline = "X-Ruomu-Deadline"
+)
+
+var (
+	errCallTimeout  = errors.New("模块调用超时")
+	errCallCanceled = errors.New("模块调用已取消")
+)
+
+// injectRuntime 注入点运行时信息, 注册时根据模块及注入点配置生成
+type injectRuntime struct {
+	moduleName string
+	module     *model.Module
+	inject     *model.ModuleInjectInfo
+	limit      *bulkhead
+	timeout    time.Duration
+	retry      *retryPolicy
+
+	requestSchema *jsonschema.Schema
+	cacheTTL      time.Duration
+	limiter       *rateLimiter
+	headerFilter  *headerFilter
+}
+
+// newInjectRuntime 请求体Schema编译失败时返回错误, 不注册该注入点, 避免未校验的请求传给模块
+func newInjectRuntime(module *model.Module, inject *model.ModuleInjectInfo) (*injectRuntime, error) {
+	requestSchema, err := compileRequestSchema(inject)
+	if err != nil {
+		return nil, err
+	}
+	return &injectRuntime{
+		moduleName: module.Name,
+		module:     module,
+		inject:     inject,
+		limit:      newBulkhead(inject.MaxConcurrent, inject.MaxQueue, inject.QueueWait),
+		timeout:    callTimeout(module.Timeout, inject.Timeout),
+		retry:      injectRetryPolicy(module, inject),
+
+		requestSchema: requestSchema,
+		cacheTTL:      newResponseCacheTTL(inject),
+		limiter:       newRateLimiter(module, inject),
+		headerFilter:  newHeaderFilter(inject),
+	}, nil
+}
+
+// callTimeout 注入点超时优先, 其次为模块默认超时, 均未配置时使用默认值
+func callTimeout(moduleTimeoutMs, injectTimeoutMs int) time.Duration {
+	if injectTimeoutMs > 0 {
+		return time.Duration(injectTimeoutMs) * time.Millisecond
+	}
+	if moduleTimeoutMs > 0 {
+		return time.Duration(moduleTimeoutMs) * time.Millisecond
+	}
+	return defaultCallTimeout
+}
+
+// requestContext 请求的上下文, 服务关闭或上层取消时结束
+// fasthttp不提供单个连接断开的通知, 客户端断开只能通过上层设置的UserContext感知
+func requestContext(ctx *fiber.Ctx) (context.Context, context.CancelFunc) {
+	c, cancel := context.WithCancel(ctx.UserContext())
+	done := ctx.Context().Done()
+	go func() {
+		select {
+		case <-done:
+			cancel()
+		case <-c.Done():
+		}
+	}()
+	return c, cancel
+}
+
+// callModule 在注入点的并发限制、超时及重试策略下调用模块
+func (m *Manager) callModule(ctx context.Context, moduleExec shared.Communicate, rt *injectRuntime, headers map[string][]string, value []byte) ([]byte, error) {
+	return rt.retry.do(ctx, func() ([]byte, error) {
+		return m.invoke(ctx, rt.moduleName, moduleExec, rt.limit, rt.timeout, rt.inject.InjectCode, copyHeaders(headers), value)
+	})
+}
+
+// callHook 调用模块的注入点(非HTTP请求), 使用模块默认超时及重试策略
+func (m *Manager) callHook(moduleName string, code string, value []byte) ([]byte, error) {
+	moduleExec, has := m.moduleExecMap[moduleName]
+	if !has {
+		return nil, errors.New(server.ResponseMsgModuleNotExists)
+	}
+	module, ok := m.modules[moduleName]
+	if !ok {
+		module = &model.Module{}
+	}
+	ctx := context.Background()
+	return newRetryPolicy(module.RetryAttempts, module.RetryBackoff, module.RetryOn).do(ctx, func() ([]byte, error) {
+		return m.invoke(ctx, moduleName, moduleExec, nil, callTimeout(module.Timeout, 0), code, nil, value)
+	})
+}
+
+// invoke 经过模块熔断器调用模块
+func (m *Manager) invoke(ctx context.Context, moduleName string, moduleExec shared.Communicate, limit *bulkhead, timeout time.Duration, code string, headers map[string][]string, value []byte) ([]byte, error) {
+	breaker := m.moduleBreakers[moduleName]
+	ticket, err := breaker.allow()
+	if err != nil {
+		return nil, err
+	}
+	result, err := invokeWithTimeout(ctx, moduleExec, limit, timeout, code, headers, value)
+	breaker.finish(ticket, err)
+	return result, err
+}
+
+// invokeWithTimeout 超时或取消后立即返回, 并发许可在模块实际返回后才释放, 避免卡死的模块无限占用资源
+func invokeWithTimeout(ctx context.Context, moduleExec shared.Communicate, limit *bulkhead, timeout time.Duration, code string, headers map[string][]string, value []byte) ([]byte, error) {
+	if err := limit.acquire(); err != nil {
+		return nil, err
+	}
+	ctx, cancel := context.WithTimeout(ctx, timeout)
+	defer cancel()
+
+	if headers == nil {
+		headers = make(map[string][]string)
+	}
+	deadline, _ := ctx.Deadline()
+	headers[HeaderCallDeadline] = []string{strconv.FormatInt(deadline.UnixMilli(), 10)}
+
+	type callResult struct {
+		data []byte
+		err  error
+	}
+	ch := make(chan callResult, 1)
+	go func() {
+		defer limit.release()
+		data, err := moduleExec.InjectCall(code, headers, value)
+		ch <- callResult{data, err}
+	}()
+
+	select {
+	case r := <-ch:
+		return r.data, r.err
+	case <-ctx.Done():
+		if errors.Is(ctx.Err(), context.DeadlineExceeded) {
+			return nil, errCallTimeout
+		}
+		return nil, errCallCanceled
+	}
+}
+
+// copyHeaders 每次调用使用独立的请求头, 避免重试时与仍在执行的上一次调用共用
+func copyHeaders(headers map[string][]string) map[string][]string {
+	result := make(map[string][]string, len(headers)+1)
+	for k, v := range headers {
+		result[k] = v
+	}
+	return result
+}
+
+// sendCallError 根据调用错误类型返回对应的响应
+func sendCallError(ctx *fiber.Ctx, err error) error {
+	var ve *validationError
+	switch {
+	case errors.Is(err, errBulkheadFull):
+		ctx.Set(fiber.HeaderRetryAfter, "1")
+		return ctx.Status(fiber.StatusTooManyRequests).JSON(&server.CommonResponse{
+			Code: constant.ResponseCodeModuleBusy,
+			Msg:  constant.ResponseMsgModuleBusy,
+		})
+	case errors.Is(err, errBulkheadTimeout):
+		return ctx.Status(fiber.StatusServiceUnavailable).JSON(&server.CommonResponse{
+			Code: constant.ResponseCodeModuleBusy,
+			Msg:  constant.ResponseMsgModuleBusy,
+		})
+	case errors.Is(err, errCallTimeout):
+		return ctx.Status(fiber.StatusGatewayTimeout).JSON(&server.CommonResponse{
+			Code: constant.ResponseCodeModuleTimeout,
+			Msg:  constant.ResponseMsgModuleTimeout,
+		})
+	case errors.Is(err, errBreakerOpen), errors.Is(err, errNoAvailableReplica):
+		return ctx.Status(fiber.StatusServiceUnavailable).JSON(&server.CommonResponse{
+			Code: constant.ResponseCodeModuleUnavailable,
+			Msg:  constant.ResponseMsgModuleUnavailable,
+		})
+	case errors.As(err, &ve):
+		return ctx.Status(fiber.StatusBadRequest).JSON(&server.CommonResponse{
+			Code: server.ResponseCodeParamParseError,
+			Msg:  ve.Error(),
+			Data: ve.errors,
+		})
+	case errors.Is(err, errStreamUnsupported):
+		return ctx.Status(fiber.StatusNotImplemented).JSON(&server.CommonResponse{
+			Code: constant.ResponseCodeStreamUnsupported,
+			Msg:  constant.ResponseMsgStreamUnsupported,
+		})
+	case errors.Is(err, errCallCanceled):
+		return ctx.Status(fiber.StatusServiceUnavailable).JSON(&server.CommonResponse{
+			Code: constant.ResponseCodeModuleTimeout,
+			Msg:  err.Error(),
+		})
+	}
+	return ctx.JSON(&server.CommonResponse{
+		Code: server.ResponseCodeUnknownError,
+		Msg:  err.Error(),
+	})
+}
@@ -0,0 +1,201 @@
+package manager
+
+import (
+	"fmt"
+	"strings"
+
+	"github.com/gofiber/fiber/v2"
+	"github.com/yockii/ruomu-core/database"
+
+	"github.com/yockii/ruomu-module/model"
+)
+
+const hostRouteOwner = "主程序"
+
+// routeEntry 已注册或待注册的路由
+type routeEntry struct {
+	method string
+	path   string
+	owner  string // 模块名称, 主程序路由为hostRouteOwner
+}
+
+// RouteConflict 路由冲突信息
+type RouteConflict struct {
+	Method        string `json:"method"`
+	Path          string `json:"path"`
+	ConflictOwner string `json:"conflictOwner"`
+	ConflictPath  string `json:"conflictPath"`
+	Exact         bool   `json:"exact"` // true-路径完全相同 false-路径模式存在重叠
+}
+
+func (c *RouteConflict) Error() string {
+	kind := "路径模式存在重叠"
+	if c.Exact {
+		kind = "路径相同"
+	}
+	return fmt.Sprintf("%s %s 与【%s】的 %s %s", c.Method, c.Path, c.ConflictOwner, c.ConflictPath, kind)
+}
+
+// RouteConflictError 模块注入点存在路由冲突
+type RouteConflictError struct {
+	Conflicts []*RouteConflict
+}
+
+func (e *RouteConflictError) Error() string {
+	return "路由冲突: " + e.Conflicts[0].Error()
+}
+
+// injectRoutes 注入点对应的路由, 非HTTP注入点及不支持的类型不产生路由
+func injectRoutes(module *model.Module, injects []*model.ModuleInjectInfo) []*routeEntry {
+	var routes []*routeEntry
+	for _, inject := range injects {
+		it, has := injectTypes[inject.Type]
+		if !has || it.method == "" {
+			continue
+		}
+		routes = append(routes, &routeEntry{
+			method: it.method,
+			path:   it.routePath(module, inject.InjectCode),
+			owner:  module.Name,
+		})
+	}
+	return routes
+}
+
+// findRouteConflicts 检查待注册路由之间以及与已有路由的冲突
+func findRouteConflicts(routes, existing []*routeEntry) []*RouteConflict {
+	var conflicts []*RouteConflict
+	for i, r := range routes {
+		others := append(append([]*routeEntry(nil), routes[i+1:]...), existing...)
+		for _, o := range others {
+			if r.method != o.method {
+				continue
+			}
+			a, b := splitRoute(r.path), splitRoute(o.path)
+			exact := normalizeRoute(a) == normalizeRoute(b)
+			if exact || routesOverlap(a, b) {
+				conflicts = append(conflicts, &RouteConflict{
+					Method:        r.method,
+					Path:          r.path,
+					ConflictOwner: o.owner,
+					ConflictPath:  o.path,
+					Exact:         exact,
+				})
+			}
+		}
+	}
+	return conflicts
+}
+
+func splitRoute(p string) []string {
+	p = strings.Trim(p, "/")
+	if p == "" {
+		return nil
+	}
+	return strings.Split(p, "/")
+}
+
+func isParamSegment(s string) bool {
+	return strings.HasPrefix(s, ":")
+}
+
+func isOptionalSegment(s string) bool {
+	return isParamSegment(s) && strings.HasSuffix(s, "?")
+}
+
+func isWildcardSegment(s string) bool {
+	return s == "*" || s == "+"
+}
+
+// normalizeRoute 参数名不影响匹配, 统一后用于判断路径是否完全相同
+func normalizeRoute(segs []string) string {
+	n := make([]string, len(segs))
+	for i, s := range segs {
+		switch {
+		case isOptionalSegment(s):
+			n[i] = ":?"
+		case isParamSegment(s):
+			n[i] = ":"
+		default:
+			n[i] = s
+		}
+	}
+	return "/" + strings.Join(n, "/")
+}
+
+// routesOverlap 是否存在同时匹配两个路径模式的请求路径
+func routesOverlap(a, b []string) bool {
+	if len(a) > 0 && isWildcardSegment(a[0]) || len(b) > 0 && isWildcardSegment(b[0]) {
+		return true
+	}
+	if len(a) > 0 && isOptionalSegment(a[0]) && routesOverlap(a[1:], b) {
+		return true
+	}
+	if len(b) > 0 && isOptionalSegment(b[0]) && routesOverlap(a, b[1:]) {
+		return true
+	}
+	if len(a) == 0 || len(b) == 0 {
+		return len(a) == 0 && len(b) == 0
+	}
+	if isParamSegment(a[0]) || isParamSegment(b[0]) || a[0] == b[0] {
+		return routesOverlap(a[1:], b[1:])
+	}
+	return false
+}
+
+// ReserveHostRoute 登记主程序自身的路由, 模块注入点不允许与其冲突
+func ReserveHostRoute(method, path string) {
+	defaultManager.hostRoutes = append(defaultManager.hostRoutes, &routeEntry{
+		method: method,
+		path:   path,
+		owner:  hostRouteOwner,
+	})
+}
+
+// ReserveAppRoutes 登记server中已注册的主程序路由, 不含模块注入的路由
+// 主程序的其他路由可能在模块加载后才注册, 在管理接口中检查冲突前调用
+func ReserveAppRoutes(app *fiber.App) {
+	m := defaultManager
+	reserved := make(map[string]bool, len(m.hostRoutes))
+	for _, r := range m.hostRoutes {
+		reserved[r.method+" "+r.path] = true
+	}
+	for _, route := range app.GetRoutes(true) {
+		key := route.Method + " " + route.Path
+		if reserved[key] || m.injectedRoutes[key] || !injectableMethod(route.Method) {
+			continue
+		}
+		reserved[key] = true
+		ReserveHostRoute(route.Method, route.Path)
+	}
+}
+
+// injectableMethod 注入点可能使用的请求方法, 其他方法的路由不会与注入点冲突
+func injectableMethod(method string) bool {
+	for _, it := range injectTypes {
+		if it.method == method {
+			return true
+		}
+	}
+	return false
+}
+
+// CheckRouteConflicts 检查模块注入点与所有已启用模块及主程序路由的冲突
+func CheckRouteConflicts(module *model.Module, injects []*model.ModuleInjectInfo) ([]*RouteConflict, error) {
+	var modules []*model.Module
+	if err := database.DB.Where("status = ?", 1).Find(&modules).Error; err != nil {
+		return nil, err
+	}
+	existing := append([]*routeEntry(nil), defaultManager.hostRoutes...)
+	for _, enabled := range modules {
+		if enabled.ID == module.ID || enabled.Name == module.Name {
+			continue
+		}
+		var enabledInjects []*model.ModuleInjectInfo
+		if err := database.DB.Find(&enabledInjects, &model.ModuleInjectInfo{ModuleID: enabled.ID}).Error; err != nil {
+			return nil, err
+		}
+		existing = append(existing, injectRoutes(enabled, enabledInjects)...)
+	}
+	return findRouteConflicts(injectRoutes(module, injects), existing), nil
+}
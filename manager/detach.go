@@ -0,0 +1,191 @@
+package manager
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"io"
+	"os"
+	"os/exec"
+	"path/filepath"
+	"strconv"
+	"time"
+
+	"github.com/hashicorp/go-hclog"
+	"github.com/hashicorp/go-plugin/runner"
+	"github.com/yockii/ruomu-core/config"
+)
+
+// pluginLogDir 开启重新连接时插件标准错误输出的日志目录, 可通过module.pluginLogDir配置
+func pluginLogDir() string {
+	if dir := config.GetString("module.pluginLogDir"); dir != "" {
+		return dir
+	}
+	return filepath.Join("logs", "plugins")
+}
+
+// detachedRunnerFunc 开启重新连接时启动插件进程的方式:
+// 插件进程使用独立的会话(进程组), 不随主程序所在进程组接收的信号退出;
+// 标准错误输出写入日志文件而非连接主程序的管道, 主程序退出后插件写日志不会因管道断开(SIGPIPE)而退出,
+// 主程序运行期间持续读取日志文件输出到主程序日志, 重新连接后插件日志仅写入日志文件;
+// 标准输出仅用于启动时的握手, 之后插件的输出由go-plugin通过gRPC转发, 不再写入该管道;
+// 标准输入不继承主程序的标准输入
+func detachedRunnerFunc(args []string, logName string) func(hclog.Logger, *exec.Cmd, string) (runner.Runner, error) {
+	return func(_ hclog.Logger, spec *exec.Cmd, _ string) (runner.Runner, error) {
+		// spec为go-plugin生成的空命令, 仅携带握手所需的环境变量
+		cmd := exec.Command(args[0], args[1:]...)
+		cmd.Env = spec.Env
+		if err := os.MkdirAll(pluginLogDir(), 0o755); err != nil {
+			return nil, err
+		}
+		logPath := filepath.Join(pluginLogDir(), logName+".log")
+		logFile, err := os.OpenFile(logPath, os.O_CREATE|os.O_WRONLY|os.O_APPEND, 0o644)
+		if err != nil {
+			return nil, err
+		}
+		info, err := logFile.Stat()
+		if err != nil {
+			_ = logFile.Close()
+			return nil, err
+		}
+		stdout, stdoutW, err := os.Pipe()
+		if err != nil {
+			_ = logFile.Close()
+			return nil, err
+		}
+		cmd.Stdout = stdoutW
+		cmd.Stderr = logFile
+		cmd.SysProcAttr = detachedSysProcAttr()
+		return &detachedRunner{
+			cmd:     cmd,
+			stdout:  stdout,
+			stdoutW: stdoutW,
+			logFile: logFile,
+			logPath: logPath,
+			offset:  info.Size(),
+			done:    make(chan struct{}),
+		}, nil
+	}
+}
+
+// detachedRunner 实现go-plugin的runner.Runner
+type detachedRunner struct {
+	cmd     *exec.Cmd
+	stdout  *os.File
+	stdoutW *os.File
+	logFile *os.File
+	logPath string
+	offset  int64
+	stderr  *logTail
+
+	done    chan struct{}
+	waitErr error
+}
+
+func (r *detachedRunner) Start(_ context.Context) error {
+	err := r.cmd.Start()
+	// 子进程已持有管道写端及日志文件, 主程序关闭自身的副本, 进程退出后读取端才能结束
+	_ = r.stdoutW.Close()
+	_ = r.logFile.Close()
+	if err != nil {
+		_ = r.stdout.Close()
+		return err
+	}
+	if r.stderr, err = newLogTail(r.logPath, r.offset, r.done); err != nil {
+		_ = r.cmd.Process.Kill()
+		_ = r.stdout.Close()
+		return err
+	}
+	go func() {
+		r.waitErr = r.cmd.Wait()
+		close(r.done)
+	}()
+	return nil
+}
+
+func (r *detachedRunner) Wait(_ context.Context) error {
+	<-r.done
+	_ = r.stdout.Close()
+	return r.waitErr
+}
+
+func (r *detachedRunner) Kill(_ context.Context) error {
+	if r.cmd.Process == nil {
+		return nil
+	}
+	if err := r.cmd.Process.Kill(); err != nil && !errors.Is(err, os.ErrProcessDone) {
+		return err
+	}
+	return nil
+}
+
+func (r *detachedRunner) Diagnose(_ context.Context) string {
+	return fmt.Sprintf("插件未完成握手, 请检查插件日志 %s", r.logPath)
+}
+
+func (r *detachedRunner) Stdout() io.ReadCloser {
+	return r.stdout
+}
+
+func (r *detachedRunner) Stderr() io.ReadCloser {
+	return r.stderr
+}
+
+func (r *detachedRunner) Name() string {
+	return r.cmd.Path
+}
+
+func (r *detachedRunner) ID() string {
+	if r.cmd.Process == nil {
+		return ""
+	}
+	return strconv.Itoa(r.cmd.Process.Pid)
+}
+
+func (r *detachedRunner) PluginToHost(network, address string) (string, string, error) {
+	return network, address, nil
+}
+
+func (r *detachedRunner) HostToPlugin(network, address string) (string, string, error) {
+	return network, address, nil
+}
+
+// logTail 从指定位置持续读取日志文件, 进程退出且读完后结束
+type logTail struct {
+	file *os.File
+	done <-chan struct{}
+}
+
+func newLogTail(path string, offset int64, done <-chan struct{}) (*logTail, error) {
+	f, err := os.Open(path)
+	if err != nil {
+		return nil, err
+	}
+	if _, err = f.Seek(offset, io.SeekStart); err != nil {
+		_ = f.Close()
+		return nil, err
+	}
+	return &logTail{file: f, done: done}, nil
+}
+
+func (t *logTail) Read(b []byte) (int, error) {
+	for {
+		n, err := t.file.Read(b)
+		if n > 0 || (err != nil && err != io.EOF) {
+			return n, err
+		}
+		select {
+		case <-t.done:
+			// 进程已退出, 读取剩余内容后结束
+			if n, err = t.file.Read(b); n > 0 {
+				return n, nil
+			}
+			return 0, io.EOF
+		case <-time.After(200 * time.Millisecond):
+		}
+	}
+}
+
+func (t *logTail) Close() error {
+	return t.file.Close()
+}
@@ -0,0 +1,98 @@
+package manager
+
+import (
+	"crypto/rand"
+	"encoding/hex"
+	"net/textproto"
+	"strings"
+	"sync"
+
+	"github.com/sirupsen/logrus"
+	"github.com/yockii/ruomu-core/config"
+	"github.com/yockii/ruomu-core/shared"
+
+	"github.com/yockii/ruomu-module/identity"
+)
+
+// defaultSensitiveHeaders 默认不转发给模块的凭证类请求头, 可通过module.sensitiveHeaders配置
+var defaultSensitiveHeaders = []string{
+	"Authorization",
+	"Proxy-Authorization",
+	"Cookie",
+}
+
+var (
+	identitySecretOnce sync.Once
+	identitySecretKey  string
+)
+
+// IdentitySecretConfigured 是否配置了身份签名密钥, 多个主程序实例共享模块(远程模块、HTTP服务类模块)时必须配置,
+// 否则各实例的随机密钥不同, 模块只能校验最后一次初始化它的实例的签名
+func IdentitySecretConfigured() bool {
+	return config.GetString(identity.ParamSecret) != ""
+}
+
+// identitySecret 身份请求头的签名主密钥, 可通过module.identitySecret配置, 未配置时启动时随机生成,
+// 主密钥不传递给模块, 各模块通过初始化参数获得由主密钥派生的模块密钥, 使用identity.Verify校验
+func identitySecret() string {
+	identitySecretOnce.Do(func() {
+		identitySecretKey = config.GetString(identity.ParamSecret)
+		if identitySecretKey != "" {
+			return
+		}
+		logrus.Warnln("未配置" + identity.ParamSecret + ", 使用随机生成的身份签名密钥, 部署多个主程序实例时模块无法校验其他实例的身份请求头")
+		bs := make([]byte, 32)
+		if _, err := rand.Read(bs); err != nil {
+			logrus.Errorln("生成身份签名密钥失败", err)
+		}
+		identitySecretKey = hex.EncodeToString(bs)
+	})
+	return identitySecretKey
+}
+
+// sensitiveHeaders 凭证类请求头
+func sensitiveHeaders() map[string]bool {
+	names := defaultSensitiveHeaders
+	if s := config.GetString("module.sensitiveHeaders"); s != "" {
+		names = strings.Split(s, ",")
+	}
+	set := make(map[string]bool)
+	for _, name := range names {
+		if name = strings.TrimSpace(name); name != "" {
+			set[textproto.CanonicalMIMEHeaderKey(name)] = true
+		}
+	}
+	return set
+}
+
+// forwardableHeaders 可转发给模块的请求头, 客户端传入的身份请求头总是移除以防伪造,
+// 凭证类请求头仅转发给开启原始请求头的受信任模块
+func forwardableHeaders(headers map[string][]string, raw bool) map[string][]string {
+	var sensitive map[string]bool
+	if !raw {
+		sensitive = sensitiveHeaders()
+	}
+	result := make(map[string][]string, len(headers))
+	for k, v := range headers {
+		name := textproto.CanonicalMIMEHeaderKey(k)
+		if strings.HasPrefix(name, identity.HeaderPrefix) ||
+			strings.EqualFold(k, shared.JwtClaimUserId) || strings.EqualFold(k, shared.JwtClaimTenantId) ||
+			sensitive[name] {
+			continue
+		}
+		result[k] = v
+	}
+	return result
+}
+
+// moduleSecret 模块的身份签名密钥, 由主密钥按模块名称派生
+func moduleSecret(moduleName string) string {
+	return identity.ModuleKey([]byte(identitySecret()), moduleName)
+}
+
+// addIdentityHeaders 使用目标模块的密钥添加身份请求头
+func addIdentityHeaders(headers map[string][]string, moduleName string, id *identity.Identity) {
+	for k, v := range identity.Headers([]byte(moduleSecret(moduleName)), id) {
+		headers[k] = []string{v}
+	}
+}
@@ -0,0 +1,253 @@
+package manager
+
+import (
+	"encoding/json"
+	"strconv"
+	"strings"
+
+	"github.com/gofiber/fiber/v2"
+	"github.com/sirupsen/logrus"
+	"github.com/yockii/ruomu-core/database"
+
+	"github.com/yockii/ruomu-module/model"
+)
+
+// headerPolicy 模块或注入点的响应头策略
+type headerPolicy struct {
+	allowOrigins  map[string]bool
+	allowAll      bool
+	allowMethods  string
+	allowHeaders  string
+	exposeHeaders string
+	credentials   bool
+	maxAge        int
+	headers       map[string]string // 安全相关及其他响应头
+}
+
+func newHeaderPolicy(p *model.ModuleHeaderPolicy) *headerPolicy {
+	hp := &headerPolicy{
+		allowOrigins:  make(map[string]bool),
+		allowMethods:  joinList(p.CorsAllowMethods),
+		allowHeaders:  joinList(p.CorsAllowHeaders),
+		exposeHeaders: joinList(p.CorsExposeHeaders),
+		credentials:   p.CorsAllowCredentials,
+		maxAge:        p.CorsMaxAge,
+		headers:       make(map[string]string),
+	}
+	for _, origin := range strings.Split(p.CorsAllowOrigins, ",") {
+		origin = strings.TrimSuffix(strings.TrimSpace(origin), "/")
+		if origin == "*" {
+			hp.allowAll = true
+		} else if origin != "" {
+			hp.allowOrigins[strings.ToLower(origin)] = true
+		}
+	}
+	if p.ExtraHeaders != "" {
+		if err := json.Unmarshal([]byte(p.ExtraHeaders), &hp.headers); err != nil {
+			logrus.Errorln("响应头策略", p.ID, "其他响应头格式错误, 忽略", err)
+		}
+	}
+	if p.ContentSecurityPolicy != "" {
+		hp.headers[fiber.HeaderContentSecurityPolicy] = p.ContentSecurityPolicy
+	}
+	if p.FrameOptions != "" {
+		hp.headers[fiber.HeaderXFrameOptions] = p.FrameOptions
+	}
+	if p.ReferrerPolicy != "" {
+		hp.headers[fiber.HeaderReferrerPolicy] = p.ReferrerPolicy
+	}
+	if p.StrictTransportSecurity != "" {
+		hp.headers[fiber.HeaderStrictTransportSecurity] = p.StrictTransportSecurity
+	}
+	if p.NoSniff {
+		hp.headers[fiber.HeaderXContentTypeOptions] = "nosniff"
+	}
+	return hp
+}
+
+// joinList 规范逗号分隔的列表
+func joinList(s string) string {
+	var items []string
+	for _, item := range strings.Split(s, ",") {
+		if item = strings.TrimSpace(item); item != "" {
+			items = append(items, item)
+		}
+	}
+	return strings.Join(items, ", ")
+}
+
+// applyCors 请求来源被允许时设置CORS响应头
+func (p *headerPolicy) applyCors(ctx *fiber.Ctx) bool {
+	origin := ctx.Get(fiber.HeaderOrigin)
+	if origin == "" || !(p.allowAll || p.allowOrigins[strings.ToLower(origin)]) {
+		return false
+	}
+	if p.allowAll && !p.credentials {
+		ctx.Set(fiber.HeaderAccessControlAllowOrigin, "*")
+	} else {
+		ctx.Set(fiber.HeaderAccessControlAllowOrigin, origin)
+		ctx.Vary(fiber.HeaderOrigin)
+	}
+	if p.credentials {
+		ctx.Set(fiber.HeaderAccessControlAllowCredentials, "true")
+	}
+	return true
+}
+
+// loadHeaderPolicies 读取模块的响应头策略, 注入点ID为0的为模块默认策略
+func loadHeaderPolicies(moduleID uint64) (map[uint64]*headerPolicy, error) {
+	var policies []*model.ModuleHeaderPolicy
+	if err := database.DB.Find(&policies, &model.ModuleHeaderPolicy{ModuleID: moduleID}).Error; err != nil {
+		return nil, err
+	}
+	result := make(map[uint64]*headerPolicy)
+	for _, p := range policies {
+		result[p.InjectID] = newHeaderPolicy(p)
+	}
+	return result, nil
+}
+
+// headerPolicyOf 注入点的响应头策略, 注入点未配置时使用模块默认策略
+func (m *Manager) headerPolicyOf(rt *injectRuntime) *headerPolicy {
+	m.policyLock.RLock()
+	defer m.policyLock.RUnlock()
+	return m.headerPolicyLocked(rt)
+}
+
+// headerPolicyLocked 同headerPolicyOf, 调用方需持有policyLock
+func (m *Manager) headerPolicyLocked(rt *injectRuntime) *headerPolicy {
+	policies := m.headerPolicies[rt.moduleName]
+	if p, has := policies[rt.inject.ID]; has {
+		return p
+	}
+	return policies[0]
+}
+
+// securityHeaders 设置注入点的CORS及安全响应头, 需在权限校验之前执行, 使错误响应同样带有CORS响应头
+func (m *Manager) securityHeaders(rt *injectRuntime) fiber.Handler {
+	return func(ctx *fiber.Ctx) error {
+		p := m.headerPolicyOf(rt)
+		if p == nil {
+			return ctx.Next()
+		}
+		if p.applyCors(ctx) && p.exposeHeaders != "" {
+			ctx.Set(fiber.HeaderAccessControlExposeHeaders, p.exposeHeaders)
+		}
+		for k, v := range p.headers {
+			ctx.Set(k, v)
+		}
+		return ctx.Next()
+	}
+}
+
+// addPreflight 登记注入点路由的预检请求, 按预检请求的方法匹配注入点, OPTIONS路由在注入点存在响应头策略时注册(见registerPreflights)
+func (m *Manager) addPreflight(path string, rt *injectRuntime) {
+	m.policyLock.Lock()
+	defer m.policyLock.Unlock()
+	m.preflightRoutes[path] = append(m.preflightRoutes[path], rt)
+}
+
+// registerPreflights 为存在响应头策略的注入点路径注册OPTIONS路由, 同一路径仅注册一次, 调用方需持有policyLock
+// 未配置策略的路径不注册, 预检请求交由主程序的其他路由或中间件处理
+func (m *Manager) registerPreflights() {
+	for path, runtimes := range m.preflightRoutes {
+		if m.preflightRegistered[path] {
+			continue
+		}
+		for _, rt := range runtimes {
+			if m.headerPolicyLocked(rt) != nil {
+				addRoute(fiber.MethodOptions, path, m.preflight(path))
+				m.preflightRegistered[path] = true
+				break
+			}
+		}
+	}
+}
+
+// preflight 处理OPTIONS请求, 非CORS预检请求返回允许的方法
+func (m *Manager) preflight(path string) fiber.Handler {
+	return func(ctx *fiber.Ctx) error {
+		m.policyLock.RLock()
+		runtimes := append([]*injectRuntime(nil), m.preflightRoutes[path]...)
+		m.policyLock.RUnlock()
+		if len(runtimes) == 0 {
+			return ctx.Next()
+		}
+
+		method := ctx.Get(fiber.HeaderAccessControlRequestMethod)
+		if ctx.Get(fiber.HeaderOrigin) == "" || method == "" {
+			var methods []string
+			for _, rt := range runtimes {
+				methods = append(methods, injectTypes[rt.inject.Type].method)
+			}
+			ctx.Set(fiber.HeaderAllow, strings.Join(append(methods, fiber.MethodOptions), ", "))
+			return ctx.SendStatus(fiber.StatusNoContent)
+		}
+
+		for _, rt := range runtimes {
+			if injectTypes[rt.inject.Type].method != method {
+				continue
+			}
+			p := m.headerPolicyOf(rt)
+			if p == nil {
+				// 注入点未配置策略, 不应答预检请求
+				return ctx.Next()
+			}
+			if !p.applyCors(ctx) {
+				break
+			}
+			allowMethods := p.allowMethods
+			if allowMethods == "" {
+				allowMethods = method
+			}
+			ctx.Set(fiber.HeaderAccessControlAllowMethods, allowMethods)
+			allowHeaders := p.allowHeaders
+			if allowHeaders == "" {
+				allowHeaders = ctx.Get(fiber.HeaderAccessControlRequestHeaders)
+				ctx.Vary(fiber.HeaderAccessControlRequestHeaders)
+			}
+			if allowHeaders != "" {
+				ctx.Set(fiber.HeaderAccessControlAllowHeaders, allowHeaders)
+			}
+			if p.maxAge > 0 {
+				ctx.Set(fiber.HeaderAccessControlMaxAge, strconv.Itoa(p.maxAge))
+			}
+			return ctx.SendStatus(fiber.StatusNoContent)
+		}
+		return ctx.SendStatus(fiber.StatusForbidden)
+	}
+}
+
+// removeHeaderPolicies 注销模块时清除模块的响应头策略及预检登记
+func (m *Manager) removeHeaderPolicies(moduleName string) {
+	m.policyLock.Lock()
+	defer m.policyLock.Unlock()
+	delete(m.headerPolicies, moduleName)
+	for path, runtimes := range m.preflightRoutes {
+		var kept []*injectRuntime
+		for _, rt := range runtimes {
+			if rt.moduleName != moduleName {
+				kept = append(kept, rt)
+			}
+		}
+		// 保留路径登记, 路由已注册无法移除, 再次注册时不重复添加
+		m.preflightRoutes[path] = kept
+	}
+}
+
+// ReloadHeaderPolicies 响应头策略变更后重新加载, 模块未加载时忽略
+func ReloadHeaderPolicies(module *model.Module) error {
+	m := defaultManager
+	if _, has := m.modules[module.Name]; !has {
+		return nil
+	}
+	policies, err := loadHeaderPolicies(module.ID)
+	if err != nil {
+		return err
+	}
+	m.policyLock.Lock()
+	defer m.policyLock.Unlock()
+	m.headerPolicies[module.Name] = policies
+	m.registerPreflights()
+	return nil
+}
@@ -1,259 +1,246 @@
 package manager
 
 import (
-	"encoding/json"
-	"os"
-	"os/exec"
+	"fmt"
 	"strings"
+	"sync"
 
 	"github.com/gofiber/fiber/v2"
-	"github.com/hashicorp/go-hclog"
-	"github.com/hashicorp/go-plugin"
 	"github.com/sirupsen/logrus"
 	"github.com/yockii/ruomu-core/config"
 	"github.com/yockii/ruomu-core/database"
 	"github.com/yockii/ruomu-core/server"
 	"github.com/yockii/ruomu-core/shared"
 
+	"github.com/yockii/ruomu-module/constant"
+	"github.com/yockii/ruomu-module/identity"
 	"github.com/yockii/ruomu-module/model"
 )
 
 var defaultManager = &Manager{
-	modules:           make(map[string]*model.Module),
-	pluginMap:         make(map[string]plugin.Plugin),
-	pluginClient:      make(map[string]*plugin.Client),
-	moduleExecMap:     make(map[string]shared.Communicate),
-	moduleInjectCodes: make(map[string][]string),
+	modules:             make(map[string]*model.Module),
+	modulePools:         make(map[string]*modulePool),
+	proxyModules:        make(map[string]*proxyModule),
+	moduleBreakers:      make(map[string]*circuitBreaker),
+	moduleRoutes:        make(map[string][]*routeEntry),
+	moduleExecMap:       make(map[string]shared.Communicate),
+	moduleInjectCodes:   make(map[string][]string),
+	headerPolicies:      make(map[string]map[uint64]*headerPolicy),
+	preflightRoutes:     make(map[string][]*injectRuntime),
+	preflightRegistered: make(map[string]bool),
+	injectedRoutes:      make(map[string]bool),
 }
 
 type Manager struct {
 	modules           map[string]*model.Module
-	pluginMap         map[string]plugin.Plugin
-	pluginClient      map[string]*plugin.Client
+	modulePools       map[string]*modulePool
+	proxyModules      map[string]*proxyModule
+	moduleBreakers    map[string]*circuitBreaker
+	moduleRoutes      map[string][]*routeEntry
+	hostRoutes        []*routeEntry
+	injectedRoutes    map[string]bool // 已注入到server的模块路由, 模块注销后路由在server重启前仍然存在
 	moduleExecMap     map[string]shared.Communicate
 	moduleInjectCodes map[string][]string
+
+	// 响应头策略可通过管理接口在运行时修改
+	policyLock      sync.RWMutex
+	headerPolicies  map[string]map[uint64]*headerPolicy
+	preflightRoutes map[string][]*injectRuntime
+	// 已注册OPTIONS路由的路径, 路由注册后无法移除
+	preflightRegistered map[string]bool
 }
 
-// RegisterModule 注入模块
-func (m *Manager) RegisterModule(module *model.Module) {
-	m.pluginMap[module.Name] = &shared.CommunicatePlugin{}
+// RegisterModule 注入模块, 模块无法加载时返回错误, 路由冲突时返回*RouteConflictError
+func (m *Manager) RegisterModule(module *model.Module) error {
 	moduleName := module.Name
 	if _, has := m.modules[moduleName]; has {
-		logrus.Warnln("模块: ", moduleName, "已存在, 忽略该模块")
-		return
+		return fmt.Errorf("模块%s已存在", moduleName)
 	}
 	logrus.Infoln("开始加载模块: ", moduleName)
 
-	var err error
-	// 加载模块
-	args := strings.Fields(module.Cmd)
-	if len(args) == 0 {
-		logrus.Errorln("模块", moduleName, "启动命令为空，无法启动")
-		return
+	isProxy := module.Kind == constant.ModuleKindHttp
+	if !isProxy && module.Kind != constant.ModuleKindRemote && len(strings.Fields(module.Cmd)) == 0 {
+		return fmt.Errorf("模块%s启动命令为空，无法启动", moduleName)
 	}
-	cmd := args[0]
-	var cmdArgs []string
-	if len(args) > 1 {
-		cmdArgs = args[1:]
+	if err := CheckRoutePrefix(module); err != nil {
+		return err
+	}
+	if (isProxy || module.Kind == constant.ModuleKindRemote) && !IdentitySecretConfigured() {
+		return fmt.Errorf("模块%s为远程模块或HTTP服务类模块, 需配置%s", moduleName, identity.ParamSecret)
 	}
 
-	client := plugin.NewClient(&plugin.ClientConfig{
-		HandshakeConfig:  shared.Handshake,
-		Plugins:          m.pluginMap,
-		Cmd:              exec.Command(cmd, cmdArgs...),
-		AllowedProtocols: []plugin.Protocol{plugin.ProtocolGRPC},
-		Logger: hclog.New(&hclog.LoggerOptions{
-			Name:   moduleName,
-			Output: os.Stdout,
-			Level:  hclog.Debug,
-		}),
-	})
-	defer func() {
-		if err != nil {
-			client.Kill()
-		}
-	}()
-
-	var cp plugin.ClientProtocol
-	cp, err = client.Client()
-	if err != nil {
-		logrus.Errorln("模块", moduleName, "加载失败", err)
-		return
+	var injects []*model.ModuleInjectInfo
+	if err := database.DB.Find(&injects, &model.ModuleInjectInfo{
+		ModuleID: module.ID,
+	}).Error; err != nil {
+		return err
 	}
-	defer func() {
-		if err != nil {
-			_ = cp.Close()
-		}
-	}()
-	var raw interface{}
-	raw, err = cp.Dispense(moduleName)
-	if err != nil {
-		logrus.Errorln("模块", moduleName, "加载失败", err)
-		return
+	// 检查注入点路由与已注册模块及主程序路由的冲突
+	routes := injectRoutes(module, injects)
+	existing := append([]*routeEntry(nil), m.hostRoutes...)
+	for _, rs := range m.moduleRoutes {
+		existing = append(existing, rs...)
+	}
+	if conflicts := findRouteConflicts(routes, existing); len(conflicts) > 0 {
+		return &RouteConflictError{Conflicts: conflicts}
 	}
-
-	instance := raw.(shared.Communicate)
-
-	logrus.Infoln("模块【", moduleName, "】加载完成，进行初始化...")
 
 	// 查询模块参数
 	var settings []*model.ModuleSettings
 
-	if err = database.DB.Find(&settings, &model.ModuleSettings{ModuleID: module.ID}).Error; err != nil {
-		logrus.Errorln(err)
-		return
+	if err := database.DB.Find(&settings, &model.ModuleSettings{ModuleID: module.ID}).Error; err != nil {
+		return err
 	}
 	var params = make(map[string]string)
 
-	// 注入的参数继承主程序的参数
-	for _, k := range config.DefaultInstance.AllKeys() {
-		params[k] = config.GetString(k)
+	// 注入的参数继承主程序的参数, 远程模块运行在其他主机上, 仅传递模块自身参数, 避免泄露数据库等主程序配置
+	if module.Kind != constant.ModuleKindRemote {
+		for _, k := range config.DefaultInstance.AllKeys() {
+			params[k] = config.GetString(k)
+		}
 	}
 
 	for _, setting := range settings {
 		params[setting.Code] = setting.Value
 	}
 	params["logger.level"] = config.GetString("logger.level")
+	params[identity.ParamSecret] = moduleSecret(moduleName)
 
-	err = instance.Initial(params)
+	policies, err := loadHeaderPolicies(module.ID)
 	if err != nil {
-		logrus.Errorln(err)
-		logrus.Warnln("模块【", moduleName, "】初始化失败")
-		return
+		return err
 	}
 
+	// 加载模块并初始化, 插件按副本数启动进程, 远程模块连接各地址, HTTP服务类模块启动服务或连接已运行的服务
+	var pool *modulePool
+	var proxied *proxyModule
+	if isProxy {
+		proxied, err = newProxyModule(module, settings)
+	} else {
+		pool, err = newModulePool(module, params)
+	}
+	if err != nil {
+		return fmt.Errorf("模块%s加载或初始化失败: %w", moduleName, err)
+	}
+	logrus.Infoln("模块【", moduleName, "】加载并初始化完成")
+
 	logrus.Infoln("开始注入模块【", moduleName, "】HTTP请求接口")
 	// 注入http请求
-	var injects []*model.ModuleInjectInfo
-	if err = database.DB.Find(&injects, &model.ModuleInjectInfo{
-		ModuleID: module.ID,
-	}).Error; err != nil {
-		logrus.Errorln(err)
-		return
-	}
 	var injectCodes []string
 	for _, inject := range injects {
-		switch inject.Type {
-		case 1:
-			server.Get(inject.InjectCode, m.checkAuthorization(inject), m.handleJsonGet(moduleName, inject.InjectCode))
-		case 2:
-			server.Post(inject.InjectCode, m.checkAuthorization(inject), m.handleJsonPost(moduleName, inject.InjectCode))
-		case 3:
-			server.Put(inject.InjectCode, m.checkAuthorization(inject), m.handleJsonPost(moduleName, inject.InjectCode))
-		case 4:
-			server.Delete(inject.InjectCode, m.checkAuthorization(inject), m.handleJsonGet(moduleName, inject.InjectCode))
-		case 11:
-			fallthrough
-		case 14:
-			server.Get(inject.InjectCode, m.checkAuthorization(inject), m.handleHtmlGet(moduleName, inject.InjectCode))
-		case 12:
-			fallthrough
-		case 13:
-			server.Post(inject.InjectCode, m.checkAuthorization(inject), m.handleHtmlPost(moduleName, inject.InjectCode))
+		it, has := injectTypes[inject.Type]
+		if !has {
+			logrus.Warnln("模块【"+moduleName+"】注入点", inject.InjectCode, "类型", inject.Type, "不支持, 忽略该注入点")
+			continue
+		}
+		if isProxy && !proxyInjectSupported(inject.Type) {
+			logrus.Warnln("模块【"+moduleName+"】为HTTP服务, 注入点", inject.InjectCode, "类型", it.name, "无法代理, 忽略该注入点")
+			continue
+		}
+		if it.method != "" {
+			if err := CheckRemoteInject(module, inject); err != nil {
+				logrus.Errorln("模块【"+moduleName+"】", err, ", 忽略该注入点")
+				continue
+			}
+			if err := CheckStaticInject(module, inject); err != nil {
+				logrus.Errorln("模块【"+moduleName+"】", err, ", 忽略该注入点")
+				continue
+			}
+			rt, err := newInjectRuntime(module, inject)
+			if err != nil {
+				logrus.Errorln("模块【"+moduleName+"】注入点", inject.InjectCode, "请求体JSON Schema编译失败, 忽略该注入点", err)
+				continue
+			}
+			path := it.routePath(module, inject.InjectCode)
+			handler := it.handler(m, rt)
+			if isProxy {
+				warnProxyIgnored(moduleName, inject)
+				handler = m.handleProxy(rt)
+			}
+			addRoute(it.method, path, m.securityHeaders(rt), m.checkRequest(rt), m.checkAuthorization(inject), m.rateLimit(rt), handler)
+			m.injectedRoutes[it.method+" "+path] = true
+			m.addPreflight(path, rt)
+			logrus.Infoln("模块【"+moduleName+"】成功注入HTTP请求:", it.method, inject.InjectCode)
+		} else {
+			logrus.Infoln("模块【"+moduleName+"】成功注册注入点:", inject.InjectCode)
 		}
-		logrus.Infoln("模块【"+moduleName+"】成功注入HTTP请求:", inject.InjectCode)
 		injectCodes = append(injectCodes, inject.InjectCode)
 	}
 
+	m.policyLock.Lock()
+	m.headerPolicies[moduleName] = policies
+	m.registerPreflights()
+	m.policyLock.Unlock()
 	m.modules[moduleName] = module
-	m.pluginClient[moduleName] = client
-	m.moduleExecMap[moduleName] = instance
+	if isProxy {
+		m.proxyModules[moduleName] = proxied
+	} else {
+		m.modulePools[moduleName] = pool
+		m.moduleExecMap[moduleName] = pool
+	}
+	m.moduleBreakers[moduleName] = newCircuitBreaker(module)
+	m.moduleRoutes[moduleName] = routes
 	m.moduleInjectCodes[moduleName] = injectCodes
 	logrus.Info("模块", moduleName, "初始化完毕")
+	return nil
 }
 
-func (m *Manager) handleHtmlGet(moduleName string, code string) fiber.Handler {
+func (m *Manager) handleHtmlGet(rt *injectRuntime) fiber.Handler {
 	return func(ctx *fiber.Ctx) error {
-		moduleExec, has := m.moduleExecMap[moduleName]
+		moduleExec, has := m.moduleExecMap[rt.moduleName]
 		if has {
-			ps := ctx.AllParams()
-			ctx.Context().QueryArgs().VisitAll(func(key, value []byte) {
-				ps[string(key)] = string(value)
-			})
-			v, _ := json.Marshal(ps)
-
-			headers := ctx.GetReqHeaders()
-			if uidInterface := ctx.Locals(shared.JwtClaimUserId); uidInterface != nil {
-				uid, ok := uidInterface.(string)
-				if ok {
-					headers[shared.JwtClaimUserId] = []string{uid}
-				}
-			}
-			if tenantIdInterface := ctx.Locals(shared.JwtClaimTenantId); tenantIdInterface != nil {
-				tenantId, ok := tenantIdInterface.(string)
-				if ok {
-					headers[shared.JwtClaimTenantId] = []string{tenantId}
-				}
+			if cached, hit := rt.cachedResult(ctx); hit {
+				return sendResult(ctx, rt, cached.Result, cached.ContentType)
 			}
-
-			result, err := moduleExec.InjectCall(code, headers, v)
+			headers, v := buildCallInput(ctx, rt, paramsValue(ctx))
+			callCtx, cancel := requestContext(ctx)
+			defer cancel()
+			result, err := m.callModule(callCtx, moduleExec, rt, headers, v)
 			if err != nil {
 				logrus.Errorln(err)
-				return ctx.JSON(&server.CommonResponse{
-					Code: server.ResponseCodeUnknownError,
-					Msg:  err.Error(),
-				})
+				return sendCallError(ctx, err)
 			}
-			ctx.Response().Header.Set(fiber.HeaderContentType, fiber.MIMETextHTMLCharsetUTF8)
-			return ctx.Send(result)
+			rt.cacheResult(ctx, result, fiber.MIMETextHTMLCharsetUTF8)
+			return sendResult(ctx, rt, result, fiber.MIMETextHTMLCharsetUTF8)
 		}
 		return ctx.SendString("Not Found")
 	}
 }
-func (m *Manager) handleHtmlPost(moduleName string, code string) fiber.Handler {
+func (m *Manager) handleHtmlPost(rt *injectRuntime) fiber.Handler {
 	return func(ctx *fiber.Ctx) error {
-		moduleExec, has := m.moduleExecMap[moduleName]
+		moduleExec, has := m.moduleExecMap[rt.moduleName]
 		if has {
-			v := ctx.Body()
-			result, err := moduleExec.InjectCall(code, ctx.GetReqHeaders(), v)
+			headers, v := buildCallInput(ctx, rt, ctx.Body())
+			callCtx, cancel := requestContext(ctx)
+			defer cancel()
+			result, err := m.callModule(callCtx, moduleExec, rt, headers, v)
 			if err != nil {
 				logrus.Errorln(err)
-				return ctx.JSON(&server.CommonResponse{
-					Code: server.ResponseCodeUnknownError,
-					Msg:  err.Error(),
-				})
+				return sendCallError(ctx, err)
 			}
-			ctx.Response().Header.Set(fiber.HeaderContentType, fiber.MIMETextHTMLCharsetUTF8)
-			return ctx.Send(result)
+			return sendResult(ctx, rt, result, fiber.MIMETextHTMLCharsetUTF8)
 		}
 		return ctx.SendString("Not Found")
 	}
 }
 
-func (m *Manager) handleJsonGet(moduleName string, code string) fiber.Handler {
+func (m *Manager) handleJsonGet(rt *injectRuntime) fiber.Handler {
 	return func(ctx *fiber.Ctx) error {
-		moduleExec, has := m.moduleExecMap[moduleName]
+		moduleExec, has := m.moduleExecMap[rt.moduleName]
 		if has {
-			ps := ctx.AllParams()
-			ctx.Context().QueryArgs().VisitAll(func(key, value []byte) {
-				ps[string(key)] = string(value)
-			})
-			v, _ := json.Marshal(ps)
-
-			headers := ctx.GetReqHeaders()
-			if uidInterface := ctx.Locals(shared.JwtClaimUserId); uidInterface != nil {
-				uid, ok := uidInterface.(string)
-				if ok {
-					headers[shared.JwtClaimUserId] = []string{uid}
-				}
-			}
-			if tenantIdInterface := ctx.Locals(shared.JwtClaimTenantId); tenantIdInterface != nil {
-				tenantId, ok := tenantIdInterface.(string)
-				if ok {
-					headers[shared.JwtClaimTenantId] = []string{tenantId}
-				}
+			if cached, hit := rt.cachedResult(ctx); hit {
+				return sendResult(ctx, rt, cached.Result, cached.ContentType)
 			}
-
-			result, err := moduleExec.InjectCall(code, headers, v)
+			headers, v := buildCallInput(ctx, rt, paramsValue(ctx))
+			callCtx, cancel := requestContext(ctx)
+			defer cancel()
+			result, err := m.callModule(callCtx, moduleExec, rt, headers, v)
 			if err != nil {
 				logrus.Errorln(err)
-				return ctx.JSON(&server.CommonResponse{
-					Code: server.ResponseCodeUnknownError,
-					Msg:  err.Error(),
-				})
+				return sendCallError(ctx, err)
 			}
-			ctx.Response().Header.Set(fiber.HeaderContentType, fiber.MIMEApplicationJSONCharsetUTF8)
-			return ctx.Send(result)
+			rt.cacheResult(ctx, result, fiber.MIMEApplicationJSONCharsetUTF8)
+			return sendResult(ctx, rt, result, fiber.MIMEApplicationJSONCharsetUTF8)
 		}
 		return ctx.JSON(&server.CommonResponse{
 			Code: server.ResponseCodeModuleNotExists,
@@ -262,21 +249,22 @@ func (m *Manager) handleJsonGet(moduleName string, code string) fiber.Handler {
 	}
 }
 
-func (m *Manager) handleJsonPost(moduleName string, code string) fiber.Handler {
+func (m *Manager) handleJsonPost(rt *injectRuntime) fiber.Handler {
 	return func(ctx *fiber.Ctx) error {
-		moduleExec, has := m.moduleExecMap[moduleName]
+		moduleExec, has := m.moduleExecMap[rt.moduleName]
 		if has {
-			v := ctx.Body()
-			result, err := moduleExec.InjectCall(code, ctx.GetReqHeaders(), v)
+			if err := rt.validateBody(ctx.Body()); err != nil {
+				return sendCallError(ctx, err)
+			}
+			headers, v := buildCallInput(ctx, rt, ctx.Body())
+			callCtx, cancel := requestContext(ctx)
+			defer cancel()
+			result, err := m.callModule(callCtx, moduleExec, rt, headers, v)
 			if err != nil {
 				logrus.Errorln(err)
-				return ctx.JSON(&server.CommonResponse{
-					Code: server.ResponseCodeUnknownError,
-					Msg:  err.Error(),
-				})
+				return sendCallError(ctx, err)
 			}
-			ctx.Response().Header.Set(fiber.HeaderContentType, fiber.MIMEApplicationJSONCharsetUTF8)
-			return ctx.Send(result)
+			return sendResult(ctx, rt, result, fiber.MIMEApplicationJSONCharsetUTF8)
 		}
 		return ctx.JSON(&server.CommonResponse{
 			Code: server.ResponseCodeModuleNotExists,
@@ -286,29 +274,55 @@ func (m *Manager) handleJsonPost(moduleName string, code string) fiber.Handler {
 }
 
 func (m *Manager) Destroy() {
-	for name, client := range m.pluginClient {
-		client.Kill()
+	for name, p := range m.proxyModules {
+		p.Close()
+		delete(m.proxyModules, name)
+		delete(m.moduleInjectCodes, name)
+		delete(m.moduleBreakers, name)
+		delete(m.moduleRoutes, name)
+		delete(m.modules, name)
+		m.removeHeaderPolicies(name)
+	}
+	for name, pool := range m.modulePools {
+		if reattachEnabled() {
+			pool.Detach()
+		} else {
+			pool.Close()
+		}
 		delete(m.moduleInjectCodes, name)
 		delete(m.moduleExecMap, name)
-		delete(m.pluginClient, name)
+		delete(m.modulePools, name)
+		delete(m.moduleBreakers, name)
+		delete(m.moduleRoutes, name)
 		delete(m.modules, name)
+		m.removeHeaderPolicies(name)
 	}
 }
 
 func (m *Manager) UnregisterModule(name string) {
-	pc, has := m.pluginClient[name]
+	pool, has := m.modulePools[name]
 	if has {
-		pc.Kill()
+		pool.Close()
+	}
+	if p, has := m.proxyModules[name]; has {
+		p.Close()
+		delete(m.proxyModules, name)
+	}
+	if err := InvalidateResponseCache(name); err != nil {
+		logrus.Errorln("模块【", name, "】响应缓存清除失败", err)
 	}
 	delete(m.moduleInjectCodes, name)
 	delete(m.moduleExecMap, name)
-	delete(m.pluginClient, name)
+	delete(m.modulePools, name)
+	delete(m.moduleBreakers, name)
+	delete(m.moduleRoutes, name)
 	delete(m.modules, name)
+	m.removeHeaderPolicies(name)
 }
 
 // RegisterModule 注入模块
-func RegisterModule(module *model.Module) {
-	defaultManager.RegisterModule(module)
+func RegisterModule(module *model.Module) error {
+	return defaultManager.RegisterModule(module)
 }
 
 // UnregisterModule 注销模块
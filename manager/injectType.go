@@ -0,0 +1,111 @@
+package manager
+
+import (
+	"fmt"
+	"regexp"
+	"strings"
+
+	"github.com/gofiber/fiber/v2"
+	"github.com/yockii/ruomu-core/config"
+	"github.com/yockii/ruomu-core/server"
+
+	"github.com/yockii/ruomu-module/constant"
+	"github.com/yockii/ruomu-module/model"
+)
+
+// injectType 注入类型定义, 新增注入类型只需在injectTypes中登记
+type injectType struct {
+	name       string
+	method     string // HTTP请求方法, 为空表示非HTTP注入点(钩子)
+	prefix     bool   // 注入点代码为路径前缀, 匹配其下的所有路径
+	idempotent bool   // 是否默认幂等, 幂等的注入点允许重试
+	handler    func(m *Manager, rt *injectRuntime) fiber.Handler
+}
+
+var injectTypes = map[int]*injectType{
+	constant.InjectTypeJsonGet:    {name: "json_get", method: fiber.MethodGet, idempotent: true, handler: (*Manager).handleJsonGet},
+	constant.InjectTypeJsonPost:   {name: "json_post", method: fiber.MethodPost, handler: (*Manager).handleJsonPost},
+	constant.InjectTypeJsonPut:    {name: "json_put", method: fiber.MethodPut, handler: (*Manager).handleJsonPost},
+	constant.InjectTypeJsonDelete: {name: "json_delete", method: fiber.MethodDelete, handler: (*Manager).handleJsonGet},
+	constant.InjectTypeHtmlGet:    {name: "html_get", method: fiber.MethodGet, idempotent: true, handler: (*Manager).handleHtmlGet},
+	constant.InjectTypeHtmlPost:   {name: "html_post", method: fiber.MethodPost, handler: (*Manager).handleHtmlPost},
+	constant.InjectTypeHtmlPut:    {name: "html_put", method: fiber.MethodPut, handler: (*Manager).handleHtmlPost},
+	constant.InjectTypeHtmlDelete: {name: "html_delete", method: fiber.MethodDelete, handler: (*Manager).handleHtmlGet},
+	constant.InjectTypeUpload:     {name: "upload", method: fiber.MethodPost, handler: (*Manager).handleUpload},
+	constant.InjectTypeStream:     {name: "stream", method: fiber.MethodGet, handler: (*Manager).handleStream},
+	constant.InjectTypeSSE:        {name: "sse", method: fiber.MethodGet, handler: (*Manager).handleSSE},
+	constant.InjectTypeWebSocket:  {name: "websocket", method: fiber.MethodGet, handler: (*Manager).handleWebSocket},
+	constant.InjectTypeStatic:     {name: "static", method: fiber.MethodGet, prefix: true, idempotent: true, handler: (*Manager).handleStatic},
+	constant.InjectTypeHook:       {name: "hook", idempotent: true},
+}
+
+// IsValidInjectType 是否为支持的注入类型
+func IsValidInjectType(t int) bool {
+	_, has := injectTypes[t]
+	return has
+}
+
+var (
+	moduleCodePattern = regexp.MustCompile(`^[A-Za-z0-9_-]+$`)
+	apiVersionPattern = regexp.MustCompile(`^[A-Za-z0-9._-]+$`)
+)
+
+// CheckRoutePrefix 模块使用路由前缀时检查模块代码及API版本, 避免挂载到其他模块的路径或产生路由参数
+func CheckRoutePrefix(module *model.Module) error {
+	if !module.RoutePrefix && !config.DefaultInstance.GetBool("module.forceRoutePrefix") {
+		return nil
+	}
+	if !moduleCodePattern.MatchString(module.Code) {
+		return fmt.Errorf("模块代码%q无效, 使用路由前缀时只能包含字母、数字、_及-", module.Code)
+	}
+	if module.ApiVersion != "" && !apiVersionPattern.MatchString(strings.Trim(module.ApiVersion, "/")) {
+		return fmt.Errorf("API版本%q无效, 只能包含字母、数字、.、_及-", module.ApiVersion)
+	}
+	return nil
+}
+
+// modulePathPrefix 模块开启路由前缀时的路径前缀 {前缀}/{模块代码}[/{版本}], 前缀可通过module.routePrefix配置, 默认/m
+// 配置module.forceRoutePrefix为true时所有模块强制使用路由前缀
+func modulePathPrefix(module *model.Module) string {
+	if !module.RoutePrefix && !config.DefaultInstance.GetBool("module.forceRoutePrefix") {
+		return ""
+	}
+	base := config.GetString("module.routePrefix")
+	if base == "" {
+		base = "/m"
+	}
+	p := "/" + strings.Trim(base, "/") + "/" + module.Code
+	if module.ApiVersion != "" {
+		p += "/" + strings.Trim(module.ApiVersion, "/")
+	}
+	return p
+}
+
+// routePath 注入点对应的路由路径
+func (it *injectType) routePath(module *model.Module, injectCode string) string {
+	p := injectCode
+	if prefix := modulePathPrefix(module); prefix != "" {
+		p = prefix + "/" + strings.TrimPrefix(injectCode, "/")
+	}
+	if it.prefix {
+		return strings.TrimSuffix(p, "/") + "/*"
+	}
+	return p
+}
+
+// addRoute 按请求方法注册路由
+func addRoute(method, path string, handlers ...fiber.Handler) {
+	switch method {
+	case fiber.MethodGet:
+		server.Get(path, handlers...)
+	case fiber.MethodPost:
+		server.Post(path, handlers...)
+	case fiber.MethodPut:
+		server.Put(path, handlers...)
+	case fiber.MethodDelete:
+		server.Delete(path, handlers...)
+	case fiber.MethodOptions:
+		// server未提供Options, 通过无前缀的路由组注册
+		server.Group("").Options(path, handlers...)
+	}
+}
@@ -0,0 +1,107 @@
+package manager
+
+import (
+	"fmt"
+	"net/textproto"
+	"strings"
+
+	"github.com/gofiber/fiber/v2"
+	"github.com/yockii/ruomu-core/server"
+
+	"github.com/yockii/ruomu-module/constant"
+	"github.com/yockii/ruomu-module/model"
+)
+
+// headerFilter 转发给模块的请求头过滤, allow为空时全部转发, deny优先于allow
+type headerFilter struct {
+	allow map[string]bool
+	deny  map[string]bool
+}
+
+func newHeaderFilter(inject *model.ModuleInjectInfo) *headerFilter {
+	f := &headerFilter{
+		allow: headerSet(inject.ForwardHeaders),
+		deny:  headerSet(inject.BlockHeaders),
+	}
+	if len(f.allow) == 0 && len(f.deny) == 0 {
+		return nil
+	}
+	return f
+}
+
+// headerSet 逗号分隔的请求头名称, 统一为规范格式
+func headerSet(s string) map[string]bool {
+	set := make(map[string]bool)
+	for _, name := range strings.Split(s, ",") {
+		if name = strings.TrimSpace(name); name != "" {
+			set[textproto.CanonicalMIMEHeaderKey(name)] = true
+		}
+	}
+	return set
+}
+
+// apply 过滤请求头, 未配置时原样返回
+func (f *headerFilter) apply(headers map[string][]string) map[string][]string {
+	if f == nil {
+		return headers
+	}
+	filtered := make(map[string][]string, len(headers))
+	for k, v := range headers {
+		name := textproto.CanonicalMIMEHeaderKey(k)
+		if f.deny[name] || (len(f.allow) > 0 && !f.allow[name]) {
+			continue
+		}
+		filtered[k] = v
+	}
+	return filtered
+}
+
+// allowedContentType 检查请求体类型, allowed为逗号分隔的MIME类型(支持text/*), 为空不限制
+func allowedContentType(allowed, contentType string) bool {
+	if allowed == "" {
+		return true
+	}
+	contentType = strings.ToLower(strings.TrimSpace(strings.Split(contentType, ";")[0]))
+	for _, a := range strings.Split(strings.ToLower(allowed), ",") {
+		a = strings.TrimSpace(a)
+		switch {
+		case a == "":
+		case strings.HasSuffix(a, "/*"):
+			if strings.HasPrefix(contentType, strings.TrimSuffix(a, "*")) {
+				return true
+			}
+		case a == contentType:
+			return true
+		}
+	}
+	return false
+}
+
+// checkRequest 校验请求体大小及类型, 上传类注入点在解析表单时按文件校验
+// 请求体由fiber按全局BodyLimit读取, 此处仅对单个注入点进一步限制
+func (m *Manager) checkRequest(rt *injectRuntime) fiber.Handler {
+	inject := rt.inject
+	if inject.Type == constant.InjectTypeUpload || (inject.MaxBodySize <= 0 && inject.ContentTypes == "") {
+		return func(ctx *fiber.Ctx) error {
+			return ctx.Next()
+		}
+	}
+	return func(ctx *fiber.Ctx) error {
+		if inject.MaxBodySize > 0 {
+			if int64(ctx.Request().Header.ContentLength()) > inject.MaxBodySize || int64(len(ctx.Body())) > inject.MaxBodySize {
+				return sendRequestError(ctx, fiber.StatusRequestEntityTooLarge, fmt.Sprintf("请求体不能超过%d字节", inject.MaxBodySize))
+			}
+		}
+		if len(ctx.Body()) > 0 && !allowedContentType(inject.ContentTypes, ctx.Get(fiber.HeaderContentType)) {
+			return sendRequestError(ctx, fiber.StatusUnsupportedMediaType, "不支持的请求体类型")
+		}
+		return ctx.Next()
+	}
+}
+
+func sendRequestError(ctx *fiber.Ctx, status int, msg string) error {
+	return ctx.Status(status).JSON(&server.CommonResponse{
+		Code: server.ResponseCodeParamParseError,
+		Msg:  msg,
+	})
+}
@@ -18,9 +18,15 @@ import (
 	"github.com/yockii/ruomu-module/model"
 )
 
+// requiresAuthorization 权限代码是否要求登录, anon或空表示不需要权限
+func requiresAuthorization(authorizationCode string) bool {
+	authorizationCode = strings.ToLower(authorizationCode)
+	return authorizationCode != "" && authorizationCode != "anon"
+}
+
 func (m *Manager) checkAuthorization(injectInfo *model.ModuleInjectInfo) fiber.Handler {
 	authorizationCode := strings.ToLower(injectInfo.AuthorizationCode)
-	if authorizationCode == "" || authorizationCode == "anon" {
+	if !requiresAuthorization(authorizationCode) {
 		return func(ctx *fiber.Ctx) error {
 			return ctx.Next()
 		}
@@ -79,7 +85,7 @@ func (m *Manager) checkAuthorization(injectInfo *model.ModuleInjectInfo) fiber.H
 				for moduleName, injectCodes := range m.moduleInjectCodes {
 					for _, code := range injectCodes {
 						if code == shared.InjectCodeAuthorizationInfoByRoleId {
-							bs, err := m.moduleExecMap[moduleName].InjectCall(shared.InjectCodeAuthorizationInfoByUserId, nil, reqBs)
+							bs, err := m.callHook(moduleName, shared.InjectCodeAuthorizationInfoByUserId, reqBs)
 							if err != nil {
 								log.Errorln(err)
 								continue
@@ -121,7 +127,7 @@ func (m *Manager) checkAuthorization(injectInfo *model.ModuleInjectInfo) fiber.H
 							for moduleName, injectCodes := range m.moduleInjectCodes {
 								for _, code := range injectCodes {
 									if code == shared.InjectCodeAuthorizationInfoByRoleId {
-										bs, err := m.moduleExecMap[moduleName].InjectCall(shared.InjectCodeAuthorizationInfoByRoleId, nil, reqBs)
+										bs, err := m.callHook(moduleName, shared.InjectCodeAuthorizationInfoByRoleId, reqBs)
 										if err != nil {
 											log.Errorln(err)
 											continue
@@ -164,6 +170,7 @@ func (m *Manager) checkAuthorization(injectInfo *model.ModuleInjectInfo) fiber.H
 			}
 
 			c.Locals(shared.JwtClaimUserId, uid)
+			c.Locals(localsUserRoles, roleIds)
 			if hasTenantId {
 				c.Locals(shared.JwtClaimTenantId, tenantId)
 			}
@@ -0,0 +1,162 @@
+package manager
+
+import (
+	"encoding/json"
+	"fmt"
+	"strings"
+
+	"github.com/gofiber/fiber/v2"
+	"github.com/sirupsen/logrus"
+	"github.com/yockii/ruomu-core/database"
+
+	"github.com/yockii/ruomu-module/constant"
+	"github.com/yockii/ruomu-module/model"
+)
+
+// openapiContentType 注入类型对应的响应内容类型
+func openapiContentType(injectType int) string {
+	switch injectType {
+	case constant.InjectTypeHtmlGet, constant.InjectTypeHtmlPost, constant.InjectTypeHtmlPut, constant.InjectTypeHtmlDelete:
+		return fiber.MIMETextHTML
+	case constant.InjectTypeStream:
+		return fiber.MIMEOctetStream
+	case constant.InjectTypeSSE:
+		return "text/event-stream"
+	case constant.InjectTypeStatic:
+		return "*/*"
+	}
+	return fiber.MIMEApplicationJSON
+}
+
+// openapiSchema 解析模块声明的JSON Schema, 未声明或格式错误时使用通用对象
+func openapiSchema(schema string) interface{} {
+	if schema != "" {
+		var s interface{}
+		if err := json.Unmarshal([]byte(schema), &s); err == nil {
+			return s
+		}
+		logrus.Warnln("注入点JSON Schema格式错误, 已忽略")
+	}
+	return map[string]interface{}{"type": "object"}
+}
+
+// openapiPath 将路由路径转换为OpenAPI路径, 并返回路径参数
+func openapiPath(routePath string) (string, []string) {
+	var params []string
+	segs := splitRoute(routePath)
+	for i, s := range segs {
+		switch {
+		case isParamSegment(s):
+			name := strings.TrimSuffix(strings.TrimPrefix(s, ":"), "?")
+			params = append(params, name)
+			segs[i] = "{" + name + "}"
+		case isWildcardSegment(s):
+			params = append(params, "path")
+			segs[i] = "{path}"
+		}
+	}
+	return "/" + strings.Join(segs, "/"), params
+}
+
+// openapiOperation 根据注入点生成OpenAPI操作定义
+func openapiOperation(module *model.Module, inject *model.ModuleInjectInfo, pathParams []string) map[string]interface{} {
+	op := map[string]interface{}{
+		"tags":        []string{module.Name},
+		"summary":     inject.Name,
+		"operationId": fmt.Sprintf("%s_%d", module.Code, inject.ID),
+	}
+	var parameters []interface{}
+	for _, p := range pathParams {
+		parameters = append(parameters, map[string]interface{}{
+			"name":     p,
+			"in":       "path",
+			"required": true,
+			"schema":   map[string]interface{}{"type": "string"},
+		})
+	}
+	if len(parameters) > 0 {
+		op["parameters"] = parameters
+	}
+
+	switch inject.Type {
+	case constant.InjectTypeJsonPost, constant.InjectTypeJsonPut:
+		op["requestBody"] = map[string]interface{}{
+			"content": map[string]interface{}{
+				fiber.MIMEApplicationJSON: map[string]interface{}{"schema": openapiSchema(inject.RequestSchema)},
+			},
+		}
+	case constant.InjectTypeUpload:
+		op["requestBody"] = map[string]interface{}{
+			"content": map[string]interface{}{
+				fiber.MIMEMultipartForm: map[string]interface{}{"schema": openapiSchema(inject.RequestSchema)},
+			},
+		}
+	}
+
+	response := map[string]interface{}{"description": "OK"}
+	contentType := openapiContentType(inject.Type)
+	if contentType == fiber.MIMEApplicationJSON {
+		response["content"] = map[string]interface{}{
+			contentType: map[string]interface{}{"schema": openapiSchema(inject.ResponseSchema)},
+		}
+	} else {
+		response["content"] = map[string]interface{}{contentType: map[string]interface{}{}}
+	}
+	if inject.Type == constant.InjectTypeWebSocket {
+		response = map[string]interface{}{"description": "WebSocket"}
+		op["responses"] = map[string]interface{}{"101": response}
+	} else {
+		op["responses"] = map[string]interface{}{"200": response}
+	}
+
+	if requiresAuthorization(inject.AuthorizationCode) {
+		op["security"] = []interface{}{map[string]interface{}{"bearerAuth": []string{}}}
+		op["x-authorization-code"] = inject.AuthorizationCode
+	}
+	return op
+}
+
+// BuildOpenAPI 根据所有已启用模块的注入点生成OpenAPI 3文档
+func BuildOpenAPI() (map[string]interface{}, error) {
+	var modules []*model.Module
+	if err := database.DB.Where("status = ?", 1).Find(&modules).Error; err != nil {
+		return nil, err
+	}
+	paths := make(map[string]interface{})
+	for _, module := range modules {
+		var injects []*model.ModuleInjectInfo
+		if err := database.DB.Find(&injects, &model.ModuleInjectInfo{ModuleID: module.ID}).Error; err != nil {
+			return nil, err
+		}
+		for _, inject := range injects {
+			it, has := injectTypes[inject.Type]
+			if !has || it.method == "" {
+				continue
+			}
+			p, params := openapiPath(it.routePath(module, inject.InjectCode))
+			item, ok := paths[p].(map[string]interface{})
+			if !ok {
+				item = make(map[string]interface{})
+				paths[p] = item
+			}
+			item[strings.ToLower(it.method)] = openapiOperation(module, inject, params)
+		}
+	}
+	return map[string]interface{}{
+		"openapi": "3.0.3",
+		"info": map[string]interface{}{
+			"title":   "ruomu modules",
+			"version": "1.0.0",
+		},
+		"paths": paths,
+		"components": map[string]interface{}{
+			"securitySchemes": map[string]interface{}{
+				"bearerAuth": map[string]interface{}{
+					"type":         "http",
+					"scheme":       "bearer",
+					"bearerFormat": "JWT",
+				},
+			},
+		},
+	}, nil
+}
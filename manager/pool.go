@@ -0,0 +1,457 @@
+package manager
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"os"
+	"os/exec"
+	"strings"
+	"sync"
+	"sync/atomic"
+	"time"
+
+	"github.com/hashicorp/go-hclog"
+	"github.com/hashicorp/go-plugin"
+	"github.com/sirupsen/logrus"
+	"github.com/yockii/ruomu-core/shared"
+	"google.golang.org/grpc"
+	"google.golang.org/grpc/health/grpc_health_v1"
+
+	"github.com/yockii/ruomu-module/constant"
+	"github.com/yockii/ruomu-module/model"
+	"github.com/yockii/ruomu-module/stream"
+)
+
+const (
+	superviseInterval   = 5 * time.Second
+	respawnBackoffStart = time.Second
+	respawnBackoffMax   = time.Minute
+)
+
+var (
+	errNoAvailableReplica = errors.New("模块没有可用的进程")
+	errStreamUnsupported  = stream.ErrUnsupported
+)
+
+// moduleReplica 模块的一个插件进程, 或远程模块的一个连接
+type moduleReplica struct {
+	client   *plugin.Client // 远程模块为nil
+	exec     shared.Communicate
+	streamer *stream.Client // 模块未提供流式插件时为nil
+	inFlight int64
+
+	// 远程模块
+	conn      *grpc.ClientConn
+	health    grpc_health_v1.HealthClient
+	unhealthy atomic.Bool
+}
+
+// exited 进程是否已退出, 远程模块为最近一次健康检查是否失败
+func (r *moduleReplica) exited() bool {
+	if r.client != nil {
+		return r.client.Exited()
+	}
+	return r.unhealthy.Load()
+}
+
+// kill 结束进程或关闭远程连接
+func (r *moduleReplica) kill() {
+	if r.client != nil {
+		r.client.Kill()
+	}
+	if r.conn != nil {
+		_ = r.conn.Close()
+	}
+}
+
+// modulePool 模块进程池, 同一模块启动多个插件进程, 注入调用在各进程间负载均衡
+// 每个进程独立监控, 退出后自动重启; 远程模块每个地址作为一个副本, 健康检查失败后重新连接
+type modulePool struct {
+	moduleID uint64
+	name     string
+	cmd      string
+	balance  string
+	plugins  map[string]plugin.Plugin
+	limit    *bulkhead
+	remote   *remoteTarget // 本地插件为nil
+
+	mu       sync.RWMutex
+	params   map[string]string
+	replicas []*moduleReplica // 为nil表示该进程不可用
+	counter  uint64
+	streams  map[trackedStream]struct{}
+
+	closed    chan struct{}
+	closeOnce sync.Once
+}
+
+func newModulePool(module *model.Module, params map[string]string) (*modulePool, error) {
+	var target *remoteTarget
+	n := module.Replicas
+	if module.Kind == constant.ModuleKindRemote {
+		var err error
+		if target, err = newRemoteTarget(module); err != nil {
+			return nil, err
+		}
+		n = len(target.addrs)
+	} else if len(strings.Fields(module.Cmd)) == 0 {
+		return nil, errors.New("启动命令为空")
+	}
+	if n < 1 {
+		n = 1
+	}
+	// 每个进程池使用独立的插件集合, 监控重启进程时读取, 不与其他模块共享
+	plugins := map[string]plugin.Plugin{
+		module.Name:                    &shared.CommunicatePlugin{},
+		stream.PluginName(module.Name): &stream.Plugin{},
+	}
+	p := &modulePool{
+		moduleID: module.ID,
+		name:     module.Name,
+		cmd:      module.Cmd,
+		balance:  module.Balance,
+		plugins:  plugins,
+		limit:    newBulkhead(module.MaxConcurrent, module.MaxQueue, module.QueueWait),
+		remote:   target,
+		params:   params,
+		replicas: make([]*moduleReplica, n),
+		streams:  make(map[trackedStream]struct{}),
+		closed:   make(chan struct{}),
+	}
+	// 结束不再使用的遗留进程: 副本数减少时多出的副本, 未开启重新连接时的所有副本
+	if p.remote == nil {
+		keep := n
+		if !reattachEnabled() {
+			keep = 0
+		}
+		p.discardReattach(keep)
+	}
+	for i := 0; i < n; i++ {
+		r, err := p.reattachOrSpawn(i)
+		if err != nil {
+			// 远程模块地址暂不可用时仍加载模块, 由监控继续重新连接
+			if p.remote != nil {
+				logrus.Warnln("模块【", p.name, "】远程连接", i, "失败, 稍后重新连接", err)
+				continue
+			}
+			p.Close()
+			return nil, err
+		}
+		p.replicas[i] = r
+	}
+	for i := 0; i < n; i++ {
+		go p.supervise(i)
+	}
+	return p, nil
+}
+
+// reattachOrSpawn 优先重新连接主程序重启前仍在运行的插件进程, 失败时启动新进程
+func (p *modulePool) reattachOrSpawn(index int) (*moduleReplica, error) {
+	if p.remote == nil {
+		if rc := p.loadReattach(index); rc != nil {
+			r, err := p.launch(index, rc)
+			if err == nil {
+				logrus.Infoln("模块【", p.name, "】进程", index, "已重新连接, pid:", rc.Pid)
+				return r, nil
+			}
+			logrus.Warnln("模块【", p.name, "】进程", index, "重新连接失败, 启动新进程", err)
+		}
+	}
+	return p.spawn(index)
+}
+
+// spawn 启动一个插件进程并完成初始化, 远程模块为连接对应地址
+func (p *modulePool) spawn(index int) (*moduleReplica, error) {
+	if p.remote != nil {
+		return p.dial(index)
+	}
+	return p.launch(index, nil)
+}
+
+// launch 启动插件进程, reattach不为nil时连接已运行的进程; 成功后保存重新连接信息
+// 重新连接的进程同样执行初始化, 使其获得最新的模块参数
+func (p *modulePool) launch(index int, reattach *plugin.ReattachConfig) (*moduleReplica, error) {
+	loggerName := p.name
+	if len(p.replicas) > 1 {
+		loggerName = fmt.Sprintf("%s#%d", p.name, index)
+	}
+	cfg := &plugin.ClientConfig{
+		HandshakeConfig:  shared.Handshake,
+		Plugins:          p.plugins,
+		AllowedProtocols: []plugin.Protocol{plugin.ProtocolGRPC},
+		Logger: hclog.New(&hclog.LoggerOptions{
+			Name:   loggerName,
+			Output: os.Stdout,
+			Level:  hclog.Debug,
+		}),
+	}
+	args := strings.Fields(p.cmd)
+	if reattach != nil {
+		cfg.Reattach = reattach
+	} else if reattachEnabled() {
+		cfg.RunnerFunc = detachedRunnerFunc(args, fmt.Sprintf("%s-%d", p.name, index))
+	} else {
+		cfg.Cmd = exec.Command(args[0], args[1:]...)
+	}
+	client := plugin.NewClient(cfg)
+
+	cp, err := client.Client()
+	if err != nil {
+		client.Kill()
+		return nil, err
+	}
+	raw, err := cp.Dispense(p.name)
+	if err != nil {
+		_ = cp.Close()
+		client.Kill()
+		return nil, err
+	}
+	instance, ok := raw.(shared.Communicate)
+	if !ok {
+		_ = cp.Close()
+		client.Kill()
+		return nil, errors.New("模块未实现通信接口")
+	}
+
+	p.mu.RLock()
+	params := p.params
+	p.mu.RUnlock()
+	if err = instance.Initial(params); err != nil {
+		_ = cp.Close()
+		client.Kill()
+		return nil, err
+	}
+	replica := &moduleReplica{
+		client: client,
+		exec:   instance,
+	}
+	if rawStream, err := cp.Dispense(stream.PluginName(p.name)); err == nil {
+		replica.streamer, _ = rawStream.(*stream.Client)
+	}
+	p.saveReattach(index, client)
+	return replica, nil
+}
+
+// supervise 监控指定进程, 进程退出后按退避时间重启
+func (p *modulePool) supervise(index int) {
+	ticker := time.NewTicker(superviseInterval)
+	defer ticker.Stop()
+	backoff := respawnBackoffStart
+	for {
+		select {
+		case <-p.closed:
+			return
+		case <-ticker.C:
+		}
+
+		p.mu.RLock()
+		r := p.replicas[index]
+		p.mu.RUnlock()
+		if r != nil && r.conn != nil {
+			r.checkHealth()
+		}
+		if r != nil && !r.exited() {
+			backoff = respawnBackoffStart
+			continue
+		}
+		if r != nil {
+			if r.conn != nil {
+				logrus.Warnln("模块【", p.name, "】远程连接", index, "健康检查失败, 准备重新连接")
+			} else {
+				logrus.Warnln("模块【", p.name, "】进程", index, "已退出, 准备重启")
+			}
+			p.mu.Lock()
+			p.replicas[index] = nil
+			p.mu.Unlock()
+			r.kill()
+		}
+
+		nr, err := p.spawn(index)
+		if err != nil {
+			logrus.Errorln("模块【", p.name, "】进程", index, "重启失败", err)
+			select {
+			case <-p.closed:
+				return
+			case <-time.After(backoff):
+			}
+			if backoff *= 2; backoff > respawnBackoffMax {
+				backoff = respawnBackoffMax
+			}
+			continue
+		}
+
+		p.mu.Lock()
+		select {
+		case <-p.closed:
+			p.mu.Unlock()
+			nr.kill()
+			return
+		default:
+		}
+		p.replicas[index] = nr
+		p.mu.Unlock()
+		backoff = respawnBackoffStart
+		logrus.Infoln("模块【", p.name, "】进程", index, "重启完成")
+	}
+}
+
+// pick 根据负载均衡策略选择一个可用进程
+func (p *modulePool) pick() *moduleReplica {
+	p.mu.RLock()
+	defer p.mu.RUnlock()
+	var alive []*moduleReplica
+	for _, r := range p.replicas {
+		if r != nil && !r.exited() {
+			alive = append(alive, r)
+		}
+	}
+	if len(alive) == 0 {
+		return nil
+	}
+	start := int(atomic.AddUint64(&p.counter, 1) % uint64(len(alive)))
+	if p.balance != constant.BalanceLeastInFlight {
+		return alive[start]
+	}
+	// 最少调用中, 相同时从轮询位置开始选择以避免总是落在第一个进程
+	var picked *moduleReplica
+	for i := 0; i < len(alive); i++ {
+		r := alive[(start+i)%len(alive)]
+		if picked == nil || atomic.LoadInt64(&r.inFlight) < atomic.LoadInt64(&picked.inFlight) {
+			picked = r
+		}
+	}
+	return picked
+}
+
+// Initial 使用新的参数重新初始化所有进程
+func (p *modulePool) Initial(params map[string]string) error {
+	p.mu.Lock()
+	p.params = params
+	replicas := append([]*moduleReplica(nil), p.replicas...)
+	p.mu.Unlock()
+	for _, r := range replicas {
+		if r == nil {
+			continue
+		}
+		if err := r.exec.Initial(params); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// InjectCall 受模块并发限制, 选择一个进程执行注入调用
+func (p *modulePool) InjectCall(code string, headers map[string][]string, value []byte) ([]byte, error) {
+	if err := p.limit.acquire(); err != nil {
+		return nil, err
+	}
+	defer p.limit.release()
+	r := p.pick()
+	if r == nil {
+		return nil, errNoAvailableReplica
+	}
+	atomic.AddInt64(&r.inFlight, 1)
+	defer atomic.AddInt64(&r.inFlight, -1)
+	return r.exec.InjectCall(code, headers, value)
+}
+
+// trackedStream 进行中的流式调用, 模块注销时统一关闭
+type trackedStream interface {
+	OnClose(f func())
+	Close() error
+}
+
+// openStream 选择一个进程发起流式调用, 调用结束前一直占用模块并发许可, 在流关闭时释放
+// 长连接类调用(如SSE、WebSocket)不受模块并发限制, 避免占满模块的调用数
+func (p *modulePool) openStream(longLived bool, open func(c *stream.Client) (trackedStream, error)) (trackedStream, error) {
+	limit := p.limit
+	if longLived {
+		limit = nil
+	}
+	if err := limit.acquire(); err != nil {
+		return nil, err
+	}
+	r := p.pick()
+	if r == nil {
+		limit.release()
+		return nil, errNoAvailableReplica
+	}
+	if r.streamer == nil {
+		limit.release()
+		return nil, errStreamUnsupported
+	}
+	atomic.AddInt64(&r.inFlight, 1)
+	s, err := open(r.streamer)
+	if err != nil {
+		atomic.AddInt64(&r.inFlight, -1)
+		limit.release()
+		return nil, err
+	}
+	p.mu.Lock()
+	p.streams[s] = struct{}{}
+	p.mu.Unlock()
+	s.OnClose(func() {
+		p.mu.Lock()
+		delete(p.streams, s)
+		p.mu.Unlock()
+		atomic.AddInt64(&r.inFlight, -1)
+		limit.release()
+	})
+	return s, nil
+}
+
+// OpenStream 发起服务端流式调用
+func (p *modulePool) OpenStream(ctx context.Context, req *stream.Request, longLived bool) (*stream.Reader, error) {
+	s, err := p.openStream(longLived, func(c *stream.Client) (trackedStream, error) {
+		return c.Call(ctx, req)
+	})
+	if err != nil {
+		return nil, err
+	}
+	return s.(*stream.Reader), nil
+}
+
+// OpenDuplex 发起双向流调用
+func (p *modulePool) OpenDuplex(ctx context.Context, req *stream.Request) (*stream.DuplexConn, error) {
+	s, err := p.openStream(true, func(c *stream.Client) (trackedStream, error) {
+		return c.Duplex(ctx, req)
+	})
+	if err != nil {
+		return nil, err
+	}
+	return s.(*stream.DuplexConn), nil
+}
+
+// closeStreams 关闭进行中的流式调用
+func (p *modulePool) closeStreams() {
+	p.mu.RLock()
+	var streams []trackedStream
+	for s := range p.streams {
+		streams = append(streams, s)
+	}
+	p.mu.RUnlock()
+	for _, s := range streams {
+		_ = s.Close()
+	}
+}
+
+// Close 停止监控, 关闭进行中的流式调用并结束所有进程
+func (p *modulePool) Close() {
+	p.closeOnce.Do(func() {
+		close(p.closed)
+	})
+	p.closeStreams()
+	if p.remote == nil {
+		p.deleteReattach(-1)
+	}
+
+	p.mu.Lock()
+	defer p.mu.Unlock()
+	for i, r := range p.replicas {
+		if r != nil {
+			r.kill()
+			p.replicas[i] = nil
+		}
+	}
+}
@@ -0,0 +1,42 @@
+//go:build !windows
+
+package manager
+
+import (
+	"os"
+	"syscall"
+	"time"
+)
+
+// detachedSysProcAttr 插件进程使用独立的会话
+func detachedSysProcAttr() *syscall.SysProcAttr {
+	return &syscall.SysProcAttr{Setsid: true}
+}
+
+// groupSysProcAttr 进程使用独立的进程组, 便于结束进程及其子进程
+func groupSysProcAttr() *syscall.SysProcAttr {
+	return &syscall.SysProcAttr{Setpgid: true}
+}
+
+// killGroup 结束进程所在的进程组, 进程组已不存在时结束进程本身
+func killGroup(process *os.Process) {
+	if err := syscall.Kill(-process.Pid, syscall.SIGKILL); err != nil {
+		_ = process.Kill()
+	}
+}
+
+// pidWait 等待非子进程退出, 通过信号0检查进程是否存在
+func pidWait(pid int) error {
+	ticker := time.NewTicker(time.Second)
+	defer ticker.Stop()
+	for range ticker.C {
+		process, err := os.FindProcess(pid)
+		if err == nil {
+			err = process.Signal(syscall.Signal(0))
+		}
+		if err != nil {
+			return nil
+		}
+	}
+	return nil
+}
@@ -0,0 +1,35 @@
+package manager
+
+import (
+	"os"
+	"os/exec"
+	"strconv"
+	"syscall"
+)
+
+// detachedSysProcAttr 插件进程使用独立的进程组
+func detachedSysProcAttr() *syscall.SysProcAttr {
+	return &syscall.SysProcAttr{CreationFlags: syscall.CREATE_NEW_PROCESS_GROUP}
+}
+
+// groupSysProcAttr 进程使用独立的进程组
+func groupSysProcAttr() *syscall.SysProcAttr {
+	return &syscall.SysProcAttr{CreationFlags: syscall.CREATE_NEW_PROCESS_GROUP}
+}
+
+// killGroup 结束进程及其子进程, taskkill执行失败时结束进程本身
+func killGroup(process *os.Process) {
+	if err := exec.Command("taskkill", "/T", "/F", "/PID", strconv.Itoa(process.Pid)).Run(); err != nil {
+		_ = process.Kill()
+	}
+}
+
+// pidWait 等待非子进程退出
+func pidWait(pid int) error {
+	process, err := os.FindProcess(pid)
+	if err != nil {
+		return nil
+	}
+	_, err = process.Wait()
+	return err
+}
@@ -0,0 +1,300 @@
+package manager
+
+import (
+	"errors"
+	"fmt"
+	"net/url"
+	"os"
+	"os/exec"
+	"strings"
+	"sync"
+	"sync/atomic"
+	"time"
+
+	"github.com/gofiber/fiber/v2"
+	"github.com/gofiber/fiber/v2/middleware/proxy"
+	"github.com/sirupsen/logrus"
+	"github.com/valyala/fasthttp"
+	"github.com/yockii/ruomu-core/server"
+
+	"github.com/yockii/ruomu-module/constant"
+	"github.com/yockii/ruomu-module/identity"
+	"github.com/yockii/ruomu-module/model"
+)
+
+const (
+	proxyHealthTimeout = 3 * time.Second
+	proxyStartupWait   = 10 * time.Second
+)
+
+var errProxyClosed = errors.New("模块已关闭")
+
+// proxyModule HTTP服务类模块, 主程序将注入点请求反向代理至模块的HTTP服务
+// 配置了启动命令时由主程序启动并监控进程, 否则仅代理至已运行的服务
+type proxyModule struct {
+	name      string
+	cmd       string
+	target    string // 服务地址, 不含末尾的/
+	healthURL string
+	prefix    string // 模块路由前缀, 代理时从请求路径中移除
+	env       []string
+	limit     *bulkhead
+
+	mu      sync.Mutex
+	process *exec.Cmd
+	exited  chan struct{} // 当前进程退出时关闭
+	healthy atomic.Bool
+
+	closed    chan struct{}
+	closeOnce sync.Once
+}
+
+func newProxyModule(module *model.Module, settings []*model.ModuleSettings) (*proxyModule, error) {
+	target, err := url.Parse(module.ProxyTarget)
+	if err != nil || target.Scheme == "" || target.Host == "" {
+		return nil, fmt.Errorf("HTTP服务地址不正确: %s", module.ProxyTarget)
+	}
+	healthPath := module.HealthPath
+	if healthPath == "" {
+		healthPath = "/"
+	}
+	p := &proxyModule{
+		name:      module.Name,
+		cmd:       module.Cmd,
+		target:    strings.TrimSuffix(module.ProxyTarget, "/"),
+		healthURL: strings.TrimSuffix(module.ProxyTarget, "/") + "/" + strings.TrimPrefix(healthPath, "/"),
+		prefix:    modulePathPrefix(module),
+		env:       proxyEnv(module, settings),
+		limit:     newBulkhead(module.MaxConcurrent, module.MaxQueue, module.QueueWait),
+		closed:    make(chan struct{}),
+	}
+	if len(strings.Fields(p.cmd)) > 0 {
+		if err = p.start(); err != nil {
+			return nil, err
+		}
+	}
+	// 等待服务就绪, 超时后仍继续加载, 由监控在服务可用后标记为健康
+	deadline := time.Now().Add(proxyStartupWait)
+	for !p.checkHealth() && time.Now().Before(deadline) {
+		time.Sleep(200 * time.Millisecond)
+	}
+	if !p.healthy.Load() {
+		logrus.Warnln("模块【", p.name, "】HTTP服务尚未就绪:", p.healthURL)
+	}
+	go p.supervise()
+	return p, nil
+}
+
+// proxyEnv 模块参数以环境变量传递给HTTP服务, 键转为大写并以_替换., 如 db.host -> DB_HOST
+func proxyEnv(module *model.Module, settings []*model.ModuleSettings) []string {
+	env := os.Environ()
+	for _, setting := range settings {
+		key := strings.ToUpper(strings.NewReplacer(".", "_", "-", "_").Replace(setting.Code))
+		env = append(env, key+"="+setting.Value)
+	}
+	return append(env, "RUOMU_IDENTITY_SECRET="+moduleSecret(module.Name))
+}
+
+// start 启动HTTP服务进程, 进程使用独立的进程组, 关闭时结束其启动的所有子进程
+// 检查关闭与启动均在p.mu内进行, 避免Close之后再启动进程
+func (p *proxyModule) start() error {
+	p.mu.Lock()
+	defer p.mu.Unlock()
+	select {
+	case <-p.closed:
+		return errProxyClosed
+	default:
+	}
+	args := strings.Fields(p.cmd)
+	cmd := exec.Command(args[0], args[1:]...)
+	cmd.Env = p.env
+	cmd.Stdout = logrus.WithField("module", p.name).WriterLevel(logrus.InfoLevel)
+	cmd.Stderr = logrus.WithField("module", p.name).WriterLevel(logrus.ErrorLevel)
+	cmd.SysProcAttr = groupSysProcAttr()
+	if err := cmd.Start(); err != nil {
+		return err
+	}
+	exited := make(chan struct{})
+	go func() {
+		_ = cmd.Wait()
+		close(exited)
+	}()
+	p.process, p.exited = cmd, exited
+	return nil
+}
+
+// processExited 由主程序启动的进程是否已退出
+func (p *proxyModule) processExited() bool {
+	p.mu.Lock()
+	exited := p.exited
+	p.mu.Unlock()
+	if exited == nil {
+		return false
+	}
+	select {
+	case <-exited:
+		return true
+	default:
+		return false
+	}
+}
+
+// checkHealth 请求健康检查地址, 返回2xx或3xx时视为健康
+func (p *proxyModule) checkHealth() bool {
+	req := fasthttp.AcquireRequest()
+	resp := fasthttp.AcquireResponse()
+	defer fasthttp.ReleaseRequest(req)
+	defer fasthttp.ReleaseResponse(resp)
+	req.SetRequestURI(p.healthURL)
+	req.Header.SetMethod(fiber.MethodGet)
+	healthy := fasthttp.DoTimeout(req, resp, proxyHealthTimeout) == nil && resp.StatusCode() < fiber.StatusBadRequest
+	if p.healthy.Swap(healthy) != healthy {
+		if healthy {
+			logrus.Infoln("模块【", p.name, "】HTTP服务恢复可用")
+		} else {
+			logrus.Warnln("模块【", p.name, "】HTTP服务健康检查失败")
+		}
+	}
+	return healthy
+}
+
+// supervise 定期健康检查, 由主程序启动的进程退出后按退避时间重启
+func (p *proxyModule) supervise() {
+	ticker := time.NewTicker(superviseInterval)
+	defer ticker.Stop()
+	backoff := respawnBackoffStart
+	for {
+		select {
+		case <-p.closed:
+			return
+		case <-ticker.C:
+		}
+
+		if p.processExited() {
+			p.healthy.Store(false)
+			logrus.Warnln("模块【", p.name, "】HTTP服务进程已退出, 准备重启")
+			if err := p.start(); err != nil {
+				if errors.Is(err, errProxyClosed) {
+					return
+				}
+				logrus.Errorln("模块【", p.name, "】HTTP服务进程重启失败", err)
+				select {
+				case <-p.closed:
+					return
+				case <-time.After(backoff):
+				}
+				if backoff *= 2; backoff > respawnBackoffMax {
+					backoff = respawnBackoffMax
+				}
+				continue
+			}
+			backoff = respawnBackoffStart
+		}
+		p.checkHealth()
+	}
+}
+
+// Close 停止监控并结束由主程序启动的进程
+func (p *proxyModule) Close() {
+	p.closeOnce.Do(func() {
+		close(p.closed)
+		p.mu.Lock()
+		defer p.mu.Unlock()
+		if p.process != nil && p.process.Process != nil {
+			killGroup(p.process.Process)
+		}
+	})
+}
+
+// proxyInjectSupported HTTP服务类模块支持的注入类型, WebSocket及非HTTP注入点无法代理,
+// 代理客户端会缓冲完整响应, 流式及SSE注入点同样不支持
+func proxyInjectSupported(t int) bool {
+	it, has := injectTypes[t]
+	return has && it.method != "" &&
+		t != constant.InjectTypeWebSocket && t != constant.InjectTypeStream && t != constant.InjectTypeSSE
+}
+
+// warnProxyIgnored 提示代理注入点不生效的配置, 请求体校验及响应缓存由模块的HTTP服务自行处理
+func warnProxyIgnored(moduleName string, inject *model.ModuleInjectInfo) {
+	if inject.RequestSchema != "" {
+		logrus.Warnln("模块【"+moduleName+"】为HTTP服务, 注入点", inject.InjectCode, "的请求体校验不生效")
+	}
+	if inject.ResponseCacheTTL > 0 {
+		logrus.Warnln("模块【"+moduleName+"】为HTTP服务, 注入点", inject.InjectCode, "的响应缓存不生效")
+	}
+}
+
+// prepareProxyRequest 按转发策略处理请求头, 并添加身份及转发相关请求头
+func prepareProxyRequest(ctx *fiber.Ctx, rt *injectRuntime) {
+	all := ctx.GetReqHeaders()
+	forwarded := rt.headerFilter.apply(forwardableHeaders(all, rt.module.ForwardRawHeaders))
+	header := &ctx.Request().Header
+	for k := range all {
+		if _, keep := forwarded[k]; !keep {
+			header.Del(k)
+		}
+	}
+
+	req := buildInjectRequest(ctx)
+	headers := make(map[string][]string)
+	addIdentityHeaders(headers, rt.moduleName, &identity.Identity{
+		UserID:    req.UserID,
+		TenantID:  req.TenantID,
+		Roles:     req.Roles,
+		RequestID: req.RequestID,
+	})
+	for k, v := range headers {
+		header.Set(k, v[0])
+	}
+	header.Set(fiber.HeaderXRequestID, req.RequestID)
+	header.Set(fiber.HeaderXForwardedFor, req.ClientIP)
+	header.Set(fiber.HeaderXForwardedHost, ctx.Hostname())
+	header.Set(fiber.HeaderXForwardedProto, ctx.Protocol())
+}
+
+// handleProxy 将请求反向代理至模块的HTTP服务
+func (m *Manager) handleProxy(rt *injectRuntime) fiber.Handler {
+	return func(ctx *fiber.Ctx) error {
+		p, has := m.proxyModules[rt.moduleName]
+		if !has {
+			return ctx.JSON(&server.CommonResponse{
+				Code: server.ResponseCodeModuleNotExists,
+				Msg:  server.ResponseMsgModuleNotExists,
+			})
+		}
+		if !p.healthy.Load() {
+			return sendCallError(ctx, errNoAvailableReplica)
+		}
+		breaker := m.moduleBreakers[rt.moduleName]
+		ticket, err := breaker.allow()
+		if err != nil {
+			return sendCallError(ctx, err)
+		}
+		if err := p.limit.acquire(); err != nil {
+			breaker.release(ticket)
+			return sendCallError(ctx, err)
+		}
+		defer p.limit.release()
+		if err := rt.limit.acquire(); err != nil {
+			breaker.release(ticket)
+			return sendCallError(ctx, err)
+		}
+		defer rt.limit.release()
+
+		path := ctx.OriginalURL()
+		if p.prefix != "" {
+			path = "/" + strings.TrimPrefix(strings.TrimPrefix(path, p.prefix), "/")
+		}
+		prepareProxyRequest(ctx, rt)
+		err = proxy.DoTimeout(ctx, p.target+path, rt.timeout)
+		breaker.done(ticket, err == nil && ctx.Response().StatusCode() < fiber.StatusInternalServerError)
+		if err != nil {
+			logrus.Errorln("模块【", rt.moduleName, "】代理请求失败", err)
+			if errors.Is(err, fasthttp.ErrTimeout) {
+				return sendCallError(ctx, errCallTimeout)
+			}
+			return sendCallError(ctx, errNoAvailableReplica)
+		}
+		return nil
+	}
+}
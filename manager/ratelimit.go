@@ -0,0 +1,233 @@
+package manager
+
+import (
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/gofiber/fiber/v2"
+	"github.com/gomodule/redigo/redis"
+	"github.com/sirupsen/logrus"
+	"github.com/yockii/ruomu-core/cache"
+	"github.com/yockii/ruomu-core/database"
+	"github.com/yockii/ruomu-core/server"
+	"github.com/yockii/ruomu-core/shared"
+
+	"github.com/yockii/ruomu-module/constant"
+	"github.com/yockii/ruomu-module/model"
+)
+
+// rateLimitScript 令牌桶, 容量为限流数+突发数, 按 限流数/时间窗口 的速率补充令牌
+// KEYS[1] 令牌桶 KEYS[2] 统计; ARGV 容量, 限流数, 时间窗口(毫秒), 当前时间(毫秒)
+// 返回 是否允许, 剩余令牌数, 需等待的毫秒数
+var rateLimitScript = redis.NewScript(2, `
+local capacity = tonumber(ARGV[1])
+local limit = tonumber(ARGV[2])
+local window = tonumber(ARGV[3])
+local now = tonumber(ARGV[4])
+local bucket = redis.call('HMGET', KEYS[1], 'tokens', 'ts')
+local tokens = tonumber(bucket[1]) or capacity
+local ts = tonumber(bucket[2]) or now
+if now > ts then
+	tokens = math.min(capacity, tokens + (now - ts) * limit / window)
+end
+local allowed = 0
+local wait = 0
+if tokens >= 1 then
+	tokens = tokens - 1
+	allowed = 1
+	redis.call('HINCRBY', KEYS[2], 'allowed', 1)
+else
+	wait = math.ceil((1 - tokens) * window / limit)
+	redis.call('HINCRBY', KEYS[2], 'rejected', 1)
+end
+redis.call('HMSET', KEYS[1], 'tokens', tostring(tokens), 'ts', now)
+redis.call('PEXPIRE', KEYS[1], math.ceil(capacity * window / limit) + 1000)
+return {allowed, math.floor(tokens), wait}
+`)
+
+// rateLimiter 注入点限流, 使用Redis令牌桶, 多个主程序实例共享限额
+type rateLimiter struct {
+	moduleName string
+	injectCode string
+	limit      int
+	burst      int
+	window     time.Duration
+	keyBy      string
+}
+
+// newRateLimiter 注入点未配置限流时返回nil
+func newRateLimiter(module *model.Module, inject *model.ModuleInjectInfo) *rateLimiter {
+	if inject.RateLimit <= 0 {
+		return nil
+	}
+	window := time.Second
+	if inject.RateWindow > 0 {
+		window = time.Duration(inject.RateWindow) * time.Second
+	}
+	burst := inject.RateBurst
+	if burst < 0 {
+		burst = 0
+	}
+	keyBy := strings.ToLower(inject.RateKey)
+	if keyBy != constant.RateKeyUser && keyBy != constant.RateKeyTenant {
+		keyBy = constant.RateKeyIP
+	}
+	return &rateLimiter{
+		moduleName: module.Name,
+		injectCode: inject.InjectCode,
+		limit:      inject.RateLimit,
+		burst:      burst,
+		window:     window,
+		keyBy:      keyBy,
+	}
+}
+
+// subject 限流标识, 按用户或租户限流但请求中没有对应信息时按客户端IP
+func (l *rateLimiter) subject(ctx *fiber.Ctx) string {
+	switch l.keyBy {
+	case constant.RateKeyUser:
+		if uid := localString(ctx, shared.JwtClaimUserId); uid != "" {
+			return constant.RateKeyUser + ":" + uid
+		}
+	case constant.RateKeyTenant:
+		if tenantId := localString(ctx, shared.JwtClaimTenantId); tenantId != "" {
+			return constant.RateKeyTenant + ":" + tenantId
+		}
+	}
+	return constant.RateKeyIP + ":" + ctx.IP()
+}
+
+func rateLimitPrefix(moduleName, injectCode string) string {
+	return constant.RedisKeyRateLimit + moduleName + ":" + injectCode + ":"
+}
+
+func rateLimitStatKey(moduleName, injectCode string) string {
+	return constant.RedisKeyRateLimitStat + moduleName + ":" + injectCode
+}
+
+// take 取一个令牌, 返回是否允许、剩余令牌数及需等待的时间; Redis不可用时放行
+func (l *rateLimiter) take(subject string) (bool, int, time.Duration) {
+	conn := cache.Get()
+	defer func() {
+		_ = conn.Close()
+	}()
+	values, err := redis.Int64s(rateLimitScript.Do(conn,
+		rateLimitPrefix(l.moduleName, l.injectCode)+subject,
+		rateLimitStatKey(l.moduleName, l.injectCode),
+		l.limit+l.burst, l.limit, l.window.Milliseconds(), time.Now().UnixMilli(),
+	))
+	if err != nil || len(values) != 3 {
+		logrus.Errorln("注入点", l.injectCode, "限流检查失败, 放行请求", err)
+		return true, l.limit + l.burst, 0
+	}
+	return values[0] == 1, int(values[1]), time.Duration(values[2]) * time.Millisecond
+}
+
+// rateLimit 注入点限流中间件, 需在权限校验之后执行以获取用户及租户信息
+func (m *Manager) rateLimit(rt *injectRuntime) fiber.Handler {
+	if rt.limiter == nil {
+		return func(ctx *fiber.Ctx) error {
+			return ctx.Next()
+		}
+	}
+	l := rt.limiter
+	return func(ctx *fiber.Ctx) error {
+		allowed, remaining, wait := l.take(l.subject(ctx))
+		ctx.Set("X-RateLimit-Limit", strconv.Itoa(l.limit))
+		ctx.Set("X-RateLimit-Remaining", strconv.Itoa(remaining))
+		if allowed {
+			return ctx.Next()
+		}
+		retryAfter := int((wait + time.Second - 1) / time.Second)
+		if retryAfter < 1 {
+			retryAfter = 1
+		}
+		ctx.Set(fiber.HeaderRetryAfter, strconv.Itoa(retryAfter))
+		return ctx.Status(fiber.StatusTooManyRequests).JSON(&server.CommonResponse{
+			Code: constant.ResponseCodeRateLimited,
+			Msg:  constant.ResponseMsgRateLimited,
+		})
+	}
+}
+
+// RateLimitBucket 限流标识当前的令牌桶
+type RateLimitBucket struct {
+	Subject string `json:"subject"`
+	Tokens  int    `json:"tokens"` // 最近一次请求后的剩余令牌数
+}
+
+// RateLimitCounter 注入点限流计数
+type RateLimitCounter struct {
+	InjectCode string             `json:"injectCode"`
+	Limit      int                `json:"limit"`
+	Window     int                `json:"window"`
+	Burst      int                `json:"burst"`
+	KeyBy      string             `json:"keyBy"`
+	Allowed    int64              `json:"allowed"`
+	Rejected   int64              `json:"rejected"`
+	Buckets    []*RateLimitBucket `json:"buckets"`
+}
+
+// RateLimitCounters 读取模块各限流注入点的计数及当前令牌桶
+func RateLimitCounters(module *model.Module) ([]*RateLimitCounter, error) {
+	var injects []*model.ModuleInjectInfo
+	if err := database.DB.Find(&injects, &model.ModuleInjectInfo{ModuleID: module.ID}).Error; err != nil {
+		return nil, err
+	}
+	conn := cache.Get()
+	defer func() {
+		_ = conn.Close()
+	}()
+
+	var counters []*RateLimitCounter
+	for _, inject := range injects {
+		l := newRateLimiter(module, inject)
+		if l == nil {
+			continue
+		}
+		counter := &RateLimitCounter{
+			InjectCode: l.injectCode,
+			Limit:      l.limit,
+			Window:     int(l.window / time.Second),
+			Burst:      l.burst,
+			KeyBy:      l.keyBy,
+		}
+		stat, err := redis.Int64Map(conn.Do("HGETALL", rateLimitStatKey(l.moduleName, l.injectCode)))
+		if err != nil {
+			return nil, err
+		}
+		counter.Allowed, counter.Rejected = stat["allowed"], stat["rejected"]
+
+		prefix := rateLimitPrefix(l.moduleName, l.injectCode)
+		cursor := 0
+		for {
+			values, err := redis.Values(conn.Do("SCAN", cursor, "MATCH", escapeRedisPattern(prefix)+"*", "COUNT", 100))
+			if err != nil {
+				return nil, err
+			}
+			if cursor, err = redis.Int(values[0], nil); err != nil {
+				return nil, err
+			}
+			keys, err := redis.Strings(values[1], nil)
+			if err != nil {
+				return nil, err
+			}
+			for _, key := range keys {
+				tokens, err := redis.Float64(conn.Do("HGET", key, "tokens"))
+				if err != nil {
+					continue
+				}
+				counter.Buckets = append(counter.Buckets, &RateLimitBucket{
+					Subject: strings.TrimPrefix(key, prefix),
+					Tokens:  int(tokens),
+				})
+			}
+			if cursor == 0 {
+				break
+			}
+		}
+		counters = append(counters, counter)
+	}
+	return counters, nil
+}
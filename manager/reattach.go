@@ -0,0 +1,266 @@
+package manager
+
+import (
+	"context"
+	"errors"
+	"net"
+	"os"
+	"strconv"
+
+	"github.com/hashicorp/go-hclog"
+	"github.com/hashicorp/go-plugin"
+	"github.com/hashicorp/go-plugin/runner"
+	"github.com/sirupsen/logrus"
+	"github.com/yockii/ruomu-core/config"
+	"github.com/yockii/ruomu-core/database"
+	"github.com/yockii/ruomu-core/shared"
+	"github.com/yockii/ruomu-core/util"
+
+	"github.com/yockii/ruomu-module/model"
+)
+
+// reattachEnabled 配置module.reattach为true时, 主程序退出时保留插件进程, 重启后重新连接,
+// 插件进程以独立会话启动且标准错误输出写入日志文件(见detachedRunnerFunc),
+// 但仍需确保部署方式不会结束主程序的所有子进程(如systemd的KillMode=control-group会结束整个cgroup)
+func reattachEnabled() bool {
+	return config.DefaultInstance.GetBool("module.reattach")
+}
+
+// saveReattach 保存插件进程的重新连接信息
+func (p *modulePool) saveReattach(index int, client *plugin.Client) {
+	if !reattachEnabled() || p.moduleID == 0 {
+		return
+	}
+	rc := client.ReattachConfig()
+	if rc == nil || rc.Addr == nil {
+		return
+	}
+	if rc.Pid == 0 {
+		// 通过detachedRunner启动的进程, go-plugin不记录进程ID
+		rc.Pid, _ = strconv.Atoi(client.ID())
+	}
+	if rc.Pid == 0 {
+		return
+	}
+	record := &model.ModuleReattach{
+		ModuleID:        p.moduleID,
+		Replica:         index,
+		Cmd:             p.cmd,
+		Protocol:        string(rc.Protocol),
+		ProtocolVersion: client.NegotiatedVersion(),
+		Network:         rc.Addr.Network(),
+		Address:         rc.Addr.String(),
+		Pid:             rc.Pid,
+	}
+	existing := new(model.ModuleReattach)
+	if err := database.DB.Where(&model.ModuleReattach{ModuleID: p.moduleID, Replica: index}).
+		Limit(1).Find(existing).Error; err != nil {
+		logrus.Errorln(err)
+		return
+	}
+	if existing.ID != 0 {
+		// 同一副本的记录被覆盖前结束记录中的进程, 避免遗留
+		if existing.Pid != rc.Pid {
+			killReattached(existing)
+		}
+		record.ID = existing.ID
+	} else {
+		record.ID = util.SnowflakeId()
+	}
+	if err := database.DB.Save(record).Error; err != nil {
+		logrus.Errorln(err)
+	}
+}
+
+// loadReattach 读取副本的重新连接信息, 模块执行命令已变更时结束原进程并删除记录
+func (p *modulePool) loadReattach(index int) *plugin.ReattachConfig {
+	if !reattachEnabled() || p.moduleID == 0 {
+		return nil
+	}
+	record := new(model.ModuleReattach)
+	if err := database.DB.Where(&model.ModuleReattach{ModuleID: p.moduleID, Replica: index}).
+		Limit(1).Find(record).Error; err != nil {
+		logrus.Errorln(err)
+		return nil
+	}
+	if record.ID == 0 {
+		return nil
+	}
+	if record.Cmd != p.cmd {
+		logrus.Infoln("模块【", p.name, "】执行命令已变更, 结束原进程", index)
+		killReattached(record)
+		if err := database.DB.Delete(record).Error; err != nil {
+			logrus.Errorln(err)
+		}
+		return nil
+	}
+	rc, err := reattachConfig(record)
+	if err != nil {
+		logrus.Warnln("模块【", p.name, "】进程", index, "重新连接地址无效", err)
+		return nil
+	}
+	return rc
+}
+
+func reattachConfig(record *model.ModuleReattach) (*plugin.ReattachConfig, error) {
+	if record.Pid == 0 {
+		return nil, errors.New("进程ID为空")
+	}
+	addr, err := reattachAddr(record.Network, record.Address)
+	if err != nil {
+		return nil, err
+	}
+	return &plugin.ReattachConfig{
+		Protocol:        plugin.Protocol(record.Protocol),
+		ProtocolVersion: record.ProtocolVersion,
+		Addr:            addr,
+		Pid:             record.Pid,
+		ReattachFunc:    reattachFunc(record.Pid, addr),
+	}, nil
+}
+
+func reattachAddr(network, address string) (net.Addr, error) {
+	switch network {
+	case "unix":
+		return &net.UnixAddr{Net: network, Name: address}, nil
+	case "tcp":
+		return net.ResolveTCPAddr(network, address)
+	}
+	return nil, errors.New("不支持的地址类型: " + network)
+}
+
+// reattachFunc 连接记录的地址成功后才认为进程仍在运行, 连接失败时不结束进程,
+// 避免进程ID已被系统复用时误结束其他进程(go-plugin默认实现会结束该进程)
+func reattachFunc(pid int, addr net.Addr) runner.ReattachFunc {
+	return func() (runner.AttachedRunner, error) {
+		conn, err := net.Dial(addr.Network(), addr.String())
+		if err != nil {
+			return nil, err
+		}
+		_ = conn.Close()
+		process, err := os.FindProcess(pid)
+		if err != nil {
+			return nil, err
+		}
+		return &attachedRunner{pid: pid, process: process}, nil
+	}
+}
+
+// attachedRunner 重新连接的插件进程, 进程不是主程序的子进程, 通过检查进程是否存在等待其退出
+type attachedRunner struct {
+	pid     int
+	process *os.Process
+}
+
+func (r *attachedRunner) Wait(_ context.Context) error {
+	return pidWait(r.pid)
+}
+
+func (r *attachedRunner) Kill(_ context.Context) error {
+	if err := r.process.Kill(); err != nil && !errors.Is(err, os.ErrProcessDone) {
+		return err
+	}
+	return nil
+}
+
+func (r *attachedRunner) ID() string {
+	return strconv.Itoa(r.pid)
+}
+
+func (r *attachedRunner) PluginToHost(network, address string) (string, string, error) {
+	return network, address, nil
+}
+
+func (r *attachedRunner) HostToPlugin(network, address string) (string, string, error) {
+	return network, address, nil
+}
+
+// killReattached 重新连接记录中的进程并结束, 无法连接时视为进程已退出
+func killReattached(record *model.ModuleReattach) {
+	rc, err := reattachConfig(record)
+	if err != nil {
+		return
+	}
+	client := plugin.NewClient(&plugin.ClientConfig{
+		HandshakeConfig:  shared.Handshake,
+		Reattach:         rc,
+		AllowedProtocols: []plugin.Protocol{plugin.ProtocolGRPC},
+		Logger:           hclog.NewNullLogger(),
+	})
+	if _, err = client.Client(); err != nil {
+		return
+	}
+	client.Kill()
+	logrus.Infoln("已结束遗留的模块插件进程, pid:", record.Pid)
+}
+
+// discardReattach 结束副本序号在keep之后(含)的遗留进程并删除记录, 用于副本数减少时
+func (p *modulePool) discardReattach(keep int) {
+	var records []*model.ModuleReattach
+	if err := database.DB.Where("module_id = ? AND replica >= ?", p.moduleID, keep).Find(&records).Error; err != nil {
+		logrus.Errorln(err)
+		return
+	}
+	for _, record := range records {
+		killReattached(record)
+	}
+	p.deleteReattach(keep)
+}
+
+// deleteReattach 删除模块的重新连接信息, keep之后(含)的副本序号的信息同样删除, keep<0时全部删除
+func (p *modulePool) deleteReattach(keep int) {
+	if p.moduleID == 0 {
+		return
+	}
+	db := database.DB.Where("module_id = ?", p.moduleID)
+	if keep >= 0 {
+		db = db.Where("replica >= ?", keep)
+	}
+	if err := db.Delete(&model.ModuleReattach{}).Error; err != nil {
+		logrus.Errorln(err)
+	}
+}
+
+// SweepReattach 结束未启用(已禁用或已删除)模块遗留的插件进程, 在加载模块前执行
+func SweepReattach(enabled []*model.Module) {
+	ids := make(map[uint64]bool, len(enabled))
+	for _, module := range enabled {
+		ids[module.ID] = true
+	}
+	var records []*model.ModuleReattach
+	if err := database.DB.Find(&records).Error; err != nil {
+		logrus.Errorln(err)
+		return
+	}
+	for _, record := range records {
+		if ids[record.ModuleID] {
+			continue
+		}
+		killReattached(record)
+		if err := database.DB.Delete(record).Error; err != nil {
+			logrus.Errorln(err)
+		}
+	}
+}
+
+// Detach 停止监控并关闭进行中的流式调用, 保留插件进程以便主程序重启后重新连接
+func (p *modulePool) Detach() {
+	p.closeOnce.Do(func() {
+		close(p.closed)
+	})
+	p.closeStreams()
+
+	p.mu.Lock()
+	defer p.mu.Unlock()
+	for i, r := range p.replicas {
+		if r == nil {
+			continue
+		}
+		// 远程模块仅关闭连接
+		if r.client == nil {
+			r.kill()
+		}
+		p.replicas[i] = nil
+	}
+	logrus.Infoln("模块【", p.name, "】已断开, 插件进程保持运行")
+}
@@ -0,0 +1,118 @@
+package manager
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"strings"
+	"time"
+
+	"github.com/yockii/ruomu-core/config"
+	"github.com/yockii/ruomu-core/shared"
+	"google.golang.org/grpc"
+	"google.golang.org/grpc/credentials"
+	"google.golang.org/grpc/credentials/insecure"
+	"google.golang.org/grpc/health/grpc_health_v1"
+
+	"github.com/yockii/ruomu-module/constant"
+	"github.com/yockii/ruomu-module/model"
+	"github.com/yockii/ruomu-module/remote"
+	"github.com/yockii/ruomu-module/stream"
+)
+
+const remoteHealthTimeout = 3 * time.Second
+
+// AllowInsecureRemote 配置module.allowInsecureRemote为true时允许远程模块使用不加密连接,
+// 模块参数及身份签名密钥将以明文传输, 仅用于可信网络
+func AllowInsecureRemote() bool {
+	return config.DefaultInstance.GetBool("module.allowInsecureRemote")
+}
+
+// CheckRemoteInject 远程模块无法读取主程序本地的上传文件, 不支持上传注入点
+func CheckRemoteInject(module *model.Module, inject *model.ModuleInjectInfo) error {
+	if module.Kind == constant.ModuleKindRemote && inject.Type == constant.InjectTypeUpload {
+		return fmt.Errorf("远程模块不支持上传注入点%s", inject.InjectCode)
+	}
+	return nil
+}
+
+// remoteTarget 远程模块的连接配置, 每个地址作为进程池的一个副本
+type remoteTarget struct {
+	addrs []string
+	creds credentials.TransportCredentials
+}
+
+func newRemoteTarget(module *model.Module) (*remoteTarget, error) {
+	var addrs []string
+	for _, addr := range strings.Split(module.RemoteAddr, ",") {
+		if addr = strings.TrimSpace(addr); addr != "" {
+			addrs = append(addrs, addr)
+		}
+	}
+	if len(addrs) == 0 {
+		return nil, errors.New("远程模块地址为空")
+	}
+	t := &remoteTarget{addrs: addrs}
+	if module.RemoteInsecure {
+		if !AllowInsecureRemote() {
+			return nil, errors.New("未允许远程模块使用不加密连接")
+		}
+		t.creds = insecure.NewCredentials()
+		return t, nil
+	}
+	cfg, err := remote.ClientTLSConfig(module.RemoteCA, module.RemoteCert, module.RemoteKey, module.RemoteServerName)
+	if err != nil {
+		return nil, err
+	}
+	t.creds = credentials.NewTLS(cfg)
+	return t, nil
+}
+
+// dial 连接远程模块, 健康检查通过后完成初始化, gRPC连接断开后自动重连, 由监控根据健康检查判断是否可用
+func (p *modulePool) dial(index int) (*moduleReplica, error) {
+	conn, err := grpc.NewClient(p.remote.addrs[index], grpc.WithTransportCredentials(p.remote.creds))
+	if err != nil {
+		return nil, err
+	}
+	replica := &moduleReplica{
+		conn:   conn,
+		health: grpc_health_v1.NewHealthClient(conn),
+	}
+	if !replica.checkHealth() {
+		_ = conn.Close()
+		return nil, errors.New("远程模块健康检查失败: " + p.remote.addrs[index])
+	}
+	raw, err := (&shared.CommunicatePlugin{}).GRPCClient(context.Background(), nil, conn)
+	if err != nil {
+		_ = conn.Close()
+		return nil, err
+	}
+	instance, ok := raw.(shared.Communicate)
+	if !ok {
+		_ = conn.Close()
+		return nil, errors.New("模块未实现通信接口")
+	}
+
+	p.mu.RLock()
+	params := p.params
+	p.mu.RUnlock()
+	if err = instance.Initial(params); err != nil {
+		_ = conn.Close()
+		return nil, err
+	}
+	replica.exec = instance
+	if rawStream, err := (&stream.Plugin{}).GRPCClient(context.Background(), nil, conn); err == nil {
+		replica.streamer, _ = rawStream.(*stream.Client)
+	}
+	return replica, nil
+}
+
+// checkHealth 远程模块健康检查, 结果用于判断副本是否可用
+func (r *moduleReplica) checkHealth() bool {
+	ctx, cancel := context.WithTimeout(context.Background(), remoteHealthTimeout)
+	defer cancel()
+	resp, err := r.health.Check(ctx, &grpc_health_v1.HealthCheckRequest{Service: remote.HealthService})
+	healthy := err == nil && resp.GetStatus() == grpc_health_v1.HealthCheckResponse_SERVING
+	r.unhealthy.Store(!healthy)
+	return healthy
+}
@@ -0,0 +1,106 @@
+package manager
+
+import (
+	"encoding/json"
+	"strconv"
+
+	"github.com/gofiber/fiber/v2"
+	"github.com/yockii/ruomu-core/shared"
+	"github.com/yockii/ruomu-core/util"
+
+	"github.com/yockii/ruomu-module/domain"
+	"github.com/yockii/ruomu-module/identity"
+)
+
+const (
+	// HeaderRequestContext 传递给模块的统一请求上下文(JSON)
+	HeaderRequestContext = "X-Ruomu-Request"
+
+	localsUserRoles = "ruomu-user-roles"
+	localsRequestId = "ruomu-request-id"
+)
+
+// localString 读取ctx.Locals中的字符串
+func localString(ctx *fiber.Ctx, key string) string {
+	if v, ok := ctx.Locals(key).(string); ok {
+		return v
+	}
+	return ""
+}
+
+// requestId 优先使用请求头中的X-Request-ID, 否则生成一个, 同一请求内保持一致
+func requestId(ctx *fiber.Ctx) string {
+	if id := localString(ctx, localsRequestId); id != "" {
+		return id
+	}
+	id := ctx.Get(fiber.HeaderXRequestID)
+	if id == "" {
+		id = strconv.FormatUint(util.SnowflakeId(), 10)
+	}
+	ctx.Locals(localsRequestId, id)
+	return id
+}
+
+// buildInjectRequest 根据请求生成统一的请求上下文, 不含请求头及请求体
+func buildInjectRequest(ctx *fiber.Ctx) *domain.InjectRequest {
+	req := &domain.InjectRequest{
+		Method:     ctx.Method(),
+		Path:       ctx.Path(),
+		PathParams: ctx.AllParams(),
+		Query:      make(map[string][]string),
+		ClientIP:   ctx.IP(),
+		UserID:     localString(ctx, shared.JwtClaimUserId),
+		TenantID:   localString(ctx, shared.JwtClaimTenantId),
+		RequestID:  requestId(ctx),
+	}
+	if roles, ok := ctx.Locals(localsUserRoles).([]string); ok {
+		req.Roles = roles
+	}
+	ctx.Context().QueryArgs().VisitAll(func(key, value []byte) {
+		req.Query[string(key)] = append(req.Query[string(key)], string(value))
+	})
+	return req
+}
+
+// paramsValue GET类注入点的调用参数, 合并路径参数及查询参数
+func paramsValue(ctx *fiber.Ctx) []byte {
+	ps := ctx.AllParams()
+	ctx.Context().QueryArgs().VisitAll(func(key, value []byte) {
+		ps[string(key)] = string(value)
+	})
+	v, _ := json.Marshal(ps)
+	return v
+}
+
+// buildCallInput 生成调用模块的请求头及调用参数, 请求头按模块转发策略及注入点配置过滤,
+// 所有注入类型均附带签名的身份信息及统一请求上下文
+func buildCallInput(ctx *fiber.Ctx, rt *injectRuntime, value []byte) (map[string][]string, []byte) {
+	req := buildInjectRequest(ctx)
+
+	forwarded := rt.headerFilter.apply(forwardableHeaders(ctx.GetReqHeaders(), rt.module.ForwardRawHeaders))
+	headers := make(map[string][]string, len(forwarded)+8)
+	for k, v := range forwarded {
+		headers[k] = v
+	}
+	if req.UserID != "" {
+		headers[shared.JwtClaimUserId] = []string{req.UserID}
+	}
+	if req.TenantID != "" {
+		headers[shared.JwtClaimTenantId] = []string{req.TenantID}
+	}
+	addIdentityHeaders(headers, rt.moduleName, &identity.Identity{
+		UserID:    req.UserID,
+		TenantID:  req.TenantID,
+		Roles:     req.Roles,
+		RequestID: req.RequestID,
+	})
+	reqBs, _ := json.Marshal(req)
+	headers[HeaderRequestContext] = []string{string(reqBs)}
+
+	if rt.inject.FullRequest {
+		req.Headers = forwarded
+		req.Body = ctx.Body()
+		value, _ = json.Marshal(req)
+	}
+	return headers, value
+}
@@ -0,0 +1,55 @@
+package manager
+
+import (
+	"encoding/json"
+	"fmt"
+	"time"
+
+	"github.com/gofiber/fiber/v2"
+
+	"github.com/yockii/ruomu-module/domain"
+)
+
+// sendResult 将模块的返回结果写入响应, 注入点开启包装响应时按包装内容设置状态码、响应头及Cookie
+func sendResult(ctx *fiber.Ctx, rt *injectRuntime, result []byte, contentType string) error {
+	if !rt.inject.Envelope {
+		ctx.Response().Header.Set(fiber.HeaderContentType, contentType)
+		return ctx.Send(result)
+	}
+
+	resp := new(domain.InjectResponse)
+	if err := json.Unmarshal(result, resp); err != nil {
+		return fmt.Errorf("模块返回的响应包装格式错误: %w", err)
+	}
+	if resp.ContentType != "" {
+		contentType = resp.ContentType
+	}
+	ctx.Response().Header.Set(fiber.HeaderContentType, contentType)
+	for k, v := range resp.Headers {
+		if k == HeaderCacheInvalidate {
+			invalidateBySignal(rt.moduleName, v)
+			continue
+		}
+		ctx.Set(k, v)
+	}
+	for _, c := range resp.Cookies {
+		cookie := &fiber.Cookie{
+			Name:     c.Name,
+			Value:    c.Value,
+			Path:     c.Path,
+			Domain:   c.Domain,
+			MaxAge:   c.MaxAge,
+			Secure:   c.Secure,
+			HTTPOnly: c.HttpOnly,
+			SameSite: c.SameSite,
+		}
+		if c.Expires > 0 {
+			cookie.Expires = time.Unix(c.Expires, 0)
+		}
+		ctx.Cookie(cookie)
+	}
+	if resp.Status > 0 {
+		ctx.Status(resp.Status)
+	}
+	return ctx.Send(resp.Body)
+}
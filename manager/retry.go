@@ -0,0 +1,99 @@
+package manager
+
+import (
+	"context"
+	"errors"
+	"strings"
+	"time"
+
+	"google.golang.org/grpc/codes"
+	"google.golang.org/grpc/status"
+
+	"github.com/yockii/ruomu-module/constant"
+	"github.com/yockii/ruomu-module/model"
+)
+
+const defaultRetryBackoff = 100 * time.Millisecond
+
+// retryPolicy 调用重试策略, 仅用于幂等的调用
+type retryPolicy struct {
+	attempts int
+	backoff  time.Duration
+	on       map[string]bool
+}
+
+// newRetryPolicy attempts为额外重试次数, <=0 时不重试返回nil; retryOn为逗号分隔的可重试错误类型, 为空时仅重试连接类错误
+func newRetryPolicy(attempts, backoffMs int, retryOn string) *retryPolicy {
+	if attempts <= 0 {
+		return nil
+	}
+	backoff := defaultRetryBackoff
+	if backoffMs > 0 {
+		backoff = time.Duration(backoffMs) * time.Millisecond
+	}
+	on := make(map[string]bool)
+	for _, c := range strings.Split(retryOn, ",") {
+		if c = strings.TrimSpace(c); c != "" {
+			on[c] = true
+		}
+	}
+	if len(on) == 0 {
+		on[constant.RetryOnUnavailable] = true
+	}
+	return &retryPolicy{
+		attempts: attempts,
+		backoff:  backoff,
+		on:       on,
+	}
+}
+
+// injectRetryPolicy GET类注入点及标记为幂等的注入点才允许重试, 注入点未配置重试次数时使用模块配置
+func injectRetryPolicy(module *model.Module, inject *model.ModuleInjectInfo) *retryPolicy {
+	if it, has := injectTypes[inject.Type]; !inject.Idempotent && (!has || !it.idempotent) {
+		return nil
+	}
+	if inject.RetryAttempts > 0 {
+		return newRetryPolicy(inject.RetryAttempts, inject.RetryBackoff, inject.RetryOn)
+	}
+	return newRetryPolicy(module.RetryAttempts, module.RetryBackoff, module.RetryOn)
+}
+
+// retryable 判断错误是否属于可重试的类型
+func (p *retryPolicy) retryable(err error) bool {
+	switch {
+	case errors.Is(err, errNoAvailableReplica):
+		return p.on[constant.RetryOnUnavailable]
+	case errors.Is(err, errCallTimeout):
+		return p.on[constant.RetryOnTimeout]
+	case errors.Is(err, errBulkheadFull), errors.Is(err, errBulkheadTimeout):
+		return p.on[constant.RetryOnBusy]
+	}
+	if s, ok := status.FromError(err); ok {
+		switch s.Code() {
+		case codes.Unavailable, codes.Aborted:
+			return p.on[constant.RetryOnUnavailable]
+		case codes.DeadlineExceeded:
+			return p.on[constant.RetryOnTimeout]
+		}
+	}
+	return false
+}
+
+// do 执行调用, 失败且可重试时按指数退避重试
+func (p *retryPolicy) do(ctx context.Context, call func() ([]byte, error)) ([]byte, error) {
+	result, err := call()
+	if p == nil {
+		return result, err
+	}
+	backoff := p.backoff
+	for i := 0; i < p.attempts && err != nil && p.retryable(err); i++ {
+		select {
+		case <-ctx.Done():
+			return result, err
+		case <-time.After(backoff):
+		}
+		backoff *= 2
+		result, err = call()
+	}
+	return result, err
+}
@@ -0,0 +1,120 @@
+package manager
+
+import (
+	"bufio"
+	"encoding/json"
+	"fmt"
+	"strings"
+	"time"
+
+	"github.com/gofiber/fiber/v2"
+	"github.com/sirupsen/logrus"
+	"github.com/yockii/ruomu-core/server"
+
+	"github.com/yockii/ruomu-module/stream"
+)
+
+const sseHeartbeatInterval = 15 * time.Second
+
+// writeSSEEvent 按text/event-stream格式写入一个事件
+func writeSSEEvent(w *bufio.Writer, e *stream.Event) error {
+	if e.ID != "" {
+		if _, err := fmt.Fprintf(w, "id: %s\n", e.ID); err != nil {
+			return err
+		}
+	}
+	if e.Event != "" {
+		if _, err := fmt.Fprintf(w, "event: %s\n", e.Event); err != nil {
+			return err
+		}
+	}
+	if e.Retry > 0 {
+		if _, err := fmt.Fprintf(w, "retry: %d\n", e.Retry); err != nil {
+			return err
+		}
+	}
+	for _, line := range strings.Split(e.Data, "\n") {
+		if _, err := fmt.Fprintf(w, "data: %s\n", line); err != nil {
+			return err
+		}
+	}
+	if _, err := w.WriteString("\n"); err != nil {
+		return err
+	}
+	return w.Flush()
+}
+
+// handleSSE 保持连接并订阅模块的事件流, 模块或客户端任一方断开, 以及模块注销时结束
+func (m *Manager) handleSSE(rt *injectRuntime) fiber.Handler {
+	return func(ctx *fiber.Ctx) error {
+		if _, has := m.moduleExecMap[rt.moduleName]; !has {
+			return ctx.JSON(&server.CommonResponse{
+				Code: server.ResponseCodeModuleNotExists,
+				Msg:  server.ResponseMsgModuleNotExists,
+			})
+		}
+		reader, err := m.openStream(ctx, rt, paramsValue(ctx), true)
+		if err != nil {
+			logrus.Errorln(err)
+			return sendCallError(ctx, err)
+		}
+
+		ctx.Set(fiber.HeaderContentType, "text/event-stream")
+		ctx.Set(fiber.HeaderCacheControl, "no-cache")
+		ctx.Set(fiber.HeaderConnection, "keep-alive")
+		ctx.Set("X-Accel-Buffering", "no")
+
+		ctx.Context().SetBodyStreamWriter(func(w *bufio.Writer) {
+			stop := make(chan struct{})
+			defer func() {
+				close(stop)
+				_ = reader.Close()
+			}()
+
+			frames := make(chan []byte)
+			done := make(chan error, 1)
+			go func() {
+				for {
+					frame, err := reader.Next()
+					if err != nil {
+						done <- err
+						return
+					}
+					select {
+					case frames <- frame:
+					case <-stop:
+						return
+					}
+				}
+			}()
+
+			heartbeat := time.NewTicker(sseHeartbeatInterval)
+			defer heartbeat.Stop()
+			for {
+				select {
+				case frame := <-frames:
+					e := new(stream.Event)
+					if err := json.Unmarshal(frame, e); err != nil {
+						logrus.Errorln("模块【", rt.moduleName, "】SSE事件格式错误", err)
+						continue
+					}
+					if err := writeSSEEvent(w, e); err != nil {
+						// 客户端已断开
+						return
+					}
+				case <-heartbeat.C:
+					// 心跳用于及时发现客户端断开
+					if _, err := w.WriteString(": ping\n\n"); err != nil {
+						return
+					}
+					if err := w.Flush(); err != nil {
+						return
+					}
+				case <-done:
+					return
+				}
+			}
+		})
+		return nil
+	}
+}
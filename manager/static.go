@@ -0,0 +1,104 @@
+package manager
+
+import (
+	"fmt"
+	"os"
+	"os/exec"
+	"path"
+	"path/filepath"
+	"strconv"
+	"strings"
+
+	"github.com/gofiber/fiber/v2"
+
+	"github.com/yockii/ruomu-module/constant"
+	"github.com/yockii/ruomu-module/model"
+)
+
+// moduleInstallDir 模块的安装目录, 即模块可执行文件所在目录, 无法确定时返回空:
+// 命令为PATH中找不到的名称, 或为带参数的PATH中的命令(如python app.py、java -jar x.jar, 可执行文件为解释器)
+func moduleInstallDir(module *model.Module) string {
+	args := strings.Fields(module.Cmd)
+	if len(args) == 0 {
+		return ""
+	}
+	bin := args[0]
+	if !strings.ContainsRune(bin, os.PathSeparator) {
+		if len(args) > 1 {
+			return ""
+		}
+		p, err := exec.LookPath(bin)
+		if err != nil {
+			return ""
+		}
+		bin = p
+	}
+	abs, err := filepath.Abs(bin)
+	if err != nil {
+		return ""
+	}
+	return filepath.Dir(abs)
+}
+
+// staticRoot 静态资源目录, 相对路径基于模块安装目录, 无法确定安装目录(如远程模块、解释器启动的模块)时返回空,
+// 避免相对路径落在主程序的工作目录或解释器目录下
+func staticRoot(module *model.Module, inject *model.ModuleInjectInfo) string {
+	dir := inject.StaticDir
+	if dir == "" {
+		dir = "static"
+	}
+	if filepath.IsAbs(dir) {
+		return filepath.Clean(dir)
+	}
+	base := moduleInstallDir(module)
+	if base == "" {
+		return ""
+	}
+	return filepath.Join(base, dir)
+}
+
+// CheckStaticInject 无法确定模块安装目录时, 静态资源注入点需设置绝对路径的静态资源目录
+func CheckStaticInject(module *model.Module, inject *model.ModuleInjectInfo) error {
+	if inject.Type != constant.InjectTypeStatic || module.Kind == constant.ModuleKindHttp {
+		return nil
+	}
+	if staticRoot(module, inject) == "" {
+		return fmt.Errorf("无法确定模块的安装目录, 静态资源注入点%s需设置绝对路径的静态资源目录", inject.InjectCode)
+	}
+	return nil
+}
+
+// handleStatic 将模块目录中的静态资源挂载在注入点路径下, 支持ETag及Last-Modified协商缓存
+func (m *Manager) handleStatic(rt *injectRuntime) fiber.Handler {
+	root := staticRoot(rt.module, rt.inject)
+	cacheControl := "no-cache"
+	if rt.inject.CacheMaxAge > 0 {
+		cacheControl = "public, max-age=" + strconv.Itoa(rt.inject.CacheMaxAge)
+	}
+	return func(ctx *fiber.Ctx) error {
+		if root == "" {
+			return ctx.SendStatus(fiber.StatusNotFound)
+		}
+		// 清理路径, 防止访问静态目录之外的文件
+		name := path.Clean("/" + ctx.Params("*"))
+		file := filepath.Join(root, filepath.FromSlash(name))
+		info, err := os.Stat(file)
+		if err == nil && info.IsDir() {
+			file = filepath.Join(file, "index.html")
+			info, err = os.Stat(file)
+		}
+		if err != nil || info.IsDir() {
+			return ctx.SendStatus(fiber.StatusNotFound)
+		}
+
+		etag := fmt.Sprintf(`W/"%x-%x"`, info.ModTime().UnixNano(), info.Size())
+		ctx.Set(fiber.HeaderETag, etag)
+		ctx.Set(fiber.HeaderCacheControl, cacheControl)
+		if match := ctx.Get(fiber.HeaderIfNoneMatch); match != "" && match == etag {
+			return ctx.SendStatus(fiber.StatusNotModified)
+		}
+		// SendFile 会设置Last-Modified并处理If-Modified-Since
+		// 不启用压缩, 避免fiber在模块目录中写入.fiber.gz缓存文件
+		return ctx.SendFile(file, false)
+	}
+}
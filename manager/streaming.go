@@ -0,0 +1,92 @@
+package manager
+
+import (
+	"time"
+
+	"github.com/gofiber/fiber/v2"
+	"github.com/sirupsen/logrus"
+	"github.com/yockii/ruomu-core/server"
+
+	"github.com/yockii/ruomu-module/stream"
+)
+
+// openStream 在注入点并发限制下经过模块熔断器发起流式调用, 超时只作用于获取响应头, 数据传输不受限制
+// 返回的Reader关闭时结束调用
+func (m *Manager) openStream(ctx *fiber.Ctx, rt *injectRuntime, value []byte, longLived bool) (*stream.Reader, error) {
+	pool, has := m.modulePools[rt.moduleName]
+	if !has {
+		return nil, errNoAvailableReplica
+	}
+	if err := rt.limit.acquire(); err != nil {
+		return nil, err
+	}
+	breaker := m.moduleBreakers[rt.moduleName]
+	ticket, err := breaker.allow()
+	if err != nil {
+		rt.limit.release()
+		return nil, err
+	}
+
+	headers, value := buildCallInput(ctx, rt, value)
+	streamCtx, cancel := requestContext(ctx)
+	timer := time.AfterFunc(rt.timeout, cancel)
+	reader, err := pool.OpenStream(streamCtx, &stream.Request{
+		Code:    rt.inject.InjectCode,
+		Headers: headers,
+		Value:   value,
+	}, longLived)
+	if !timer.Stop() {
+		if err == nil {
+			_ = reader.Close()
+		}
+		err = errCallTimeout
+	}
+	breaker.finish(ticket, err)
+	if err != nil {
+		cancel()
+		rt.limit.release()
+		return nil, err
+	}
+	reader.OnClose(cancel)
+	reader.OnClose(rt.limit.release)
+	return reader, nil
+}
+
+// handleStream 流式响应, 模块分段返回的数据直接写入响应, 适用于大文件下载及导出
+func (m *Manager) handleStream(rt *injectRuntime) fiber.Handler {
+	return func(ctx *fiber.Ctx) error {
+		if _, has := m.moduleExecMap[rt.moduleName]; !has {
+			return ctx.JSON(&server.CommonResponse{
+				Code: server.ResponseCodeModuleNotExists,
+				Msg:  server.ResponseMsgModuleNotExists,
+			})
+		}
+		reader, err := m.openStream(ctx, rt, paramsValue(ctx), false)
+		if err != nil {
+			logrus.Errorln(err)
+			return sendCallError(ctx, err)
+		}
+
+		h := reader.Header()
+		for k, v := range h.Headers {
+			ctx.Set(k, v)
+		}
+		ctx.Response().Header.Set(fiber.HeaderContentType, fiber.MIMEOctetStream)
+		if h.FileName != "" {
+			// 同时根据文件扩展名设置Content-Type
+			ctx.Attachment(h.FileName)
+		}
+		if h.ContentType != "" {
+			ctx.Response().Header.Set(fiber.HeaderContentType, h.ContentType)
+		}
+		if h.Status > 0 {
+			ctx.Status(h.Status)
+		}
+		size := -1
+		if h.ContentLength > 0 {
+			size = int(h.ContentLength)
+		}
+		// 响应体在处理函数返回后才被读取, reader读取完毕后由fasthttp关闭
+		return ctx.SendStream(reader, size)
+	}
+}
@@ -0,0 +1,140 @@
+package manager
+
+import (
+	"encoding/json"
+	"fmt"
+	"mime/multipart"
+	"os"
+	"path/filepath"
+	"strings"
+
+	"github.com/gofiber/fiber/v2"
+	"github.com/sirupsen/logrus"
+	"github.com/yockii/ruomu-core/config"
+	"github.com/yockii/ruomu-core/server"
+
+	"github.com/yockii/ruomu-module/domain"
+)
+
+// uploadDir 上传文件的临时目录, 可通过module.uploadDir配置
+func uploadDir() string {
+	if dir := config.GetString("module.uploadDir"); dir != "" {
+		return dir
+	}
+	return filepath.Join(os.TempDir(), "ruomu-upload")
+}
+
+// allowedUploadType 检查文件类型是否允许, allowed为逗号分隔的MIME类型(支持image/*)或扩展名(.pdf), 为空不限制
+func allowedUploadType(allowed, fileName, contentType string) bool {
+	if allowed == "" {
+		return true
+	}
+	ext := strings.ToLower(filepath.Ext(fileName))
+	contentType = strings.ToLower(strings.TrimSpace(strings.Split(contentType, ";")[0]))
+	for _, a := range strings.Split(strings.ToLower(allowed), ",") {
+		a = strings.TrimSpace(a)
+		switch {
+		case a == "":
+		case strings.HasPrefix(a, "."):
+			if a == ext {
+				return true
+			}
+		case strings.HasSuffix(a, "/*"):
+			if strings.HasPrefix(contentType, strings.TrimSuffix(a, "*")) {
+				return true
+			}
+		case a == contentType:
+			return true
+		}
+	}
+	return false
+}
+
+// handleUpload 解析multipart表单, 校验文件大小及类型后保存至临时目录, 将文件信息及表单字段传递给模块
+func (m *Manager) handleUpload(rt *injectRuntime) fiber.Handler {
+	return func(ctx *fiber.Ctx) error {
+		moduleExec, has := m.moduleExecMap[rt.moduleName]
+		if !has {
+			return ctx.JSON(&server.CommonResponse{
+				Code: server.ResponseCodeModuleNotExists,
+				Msg:  server.ResponseMsgModuleNotExists,
+			})
+		}
+
+		form, err := ctx.MultipartForm()
+		if err != nil {
+			logrus.Errorln(err)
+			return ctx.Status(fiber.StatusBadRequest).JSON(&server.CommonResponse{
+				Code: server.ResponseCodeParamParseError,
+				Msg:  server.ResponseMsgParamParseError,
+			})
+		}
+		defer ctx.Request().RemoveMultipartFormFiles()
+
+		upload := &domain.InjectUpload{
+			Fields: form.Value,
+		}
+		var headers []*multipart.FileHeader
+		var total int64
+		for field, files := range form.File {
+			for _, fh := range files {
+				headers = append(headers, fh)
+				total += fh.Size
+				if rt.inject.MaxFiles > 0 && len(headers) > rt.inject.MaxFiles {
+					return sendRequestError(ctx, fiber.StatusRequestEntityTooLarge, fmt.Sprintf("上传文件数量不能超过%d个", rt.inject.MaxFiles))
+				}
+				if rt.inject.MaxFileSize > 0 && fh.Size > rt.inject.MaxFileSize {
+					return sendRequestError(ctx, fiber.StatusRequestEntityTooLarge, fmt.Sprintf("文件%s超过大小限制", fh.Filename))
+				}
+				if rt.inject.MaxBodySize > 0 && total > rt.inject.MaxBodySize {
+					return sendRequestError(ctx, fiber.StatusRequestEntityTooLarge, "上传文件总大小超过限制")
+				}
+				contentType := fh.Header.Get(fiber.HeaderContentType)
+				if !allowedUploadType(rt.inject.AllowedTypes, fh.Filename, contentType) {
+					return sendRequestError(ctx, fiber.StatusUnsupportedMediaType, fmt.Sprintf("文件%s的类型不允许上传", fh.Filename))
+				}
+				upload.Files = append(upload.Files, &domain.UploadFile{
+					Field:       field,
+					FileName:    filepath.Base(fh.Filename),
+					ContentType: contentType,
+					Size:        fh.Size,
+				})
+			}
+		}
+
+		// 文件保存至主程序生成的临时目录, 调用结束后删除, 模块需要保留的文件应自行复制
+		// 目录名不可使用请求头等客户端提供的内容, 避免路径穿越删除其他目录
+		if err = os.MkdirAll(uploadDir(), 0o700); err != nil {
+			logrus.Errorln(err)
+			return sendCallError(ctx, err)
+		}
+		dir, err := os.MkdirTemp(uploadDir(), "req-")
+		if err != nil {
+			logrus.Errorln(err)
+			return sendCallError(ctx, err)
+		}
+		defer func() {
+			if err := os.RemoveAll(dir); err != nil {
+				logrus.Errorln(err)
+			}
+		}()
+		for i, f := range upload.Files {
+			f.Path = filepath.Join(dir, fmt.Sprintf("%d_%s", i, f.FileName))
+			if err = ctx.SaveFile(headers[i], f.Path); err != nil {
+				logrus.Errorln(err)
+				return sendCallError(ctx, err)
+			}
+		}
+
+		uploadBs, _ := json.Marshal(upload)
+		callHeaders, v := buildCallInput(ctx, rt, uploadBs)
+		callCtx, cancel := requestContext(ctx)
+		defer cancel()
+		result, err := m.callModule(callCtx, moduleExec, rt, callHeaders, v)
+		if err != nil {
+			logrus.Errorln(err)
+			return sendCallError(ctx, err)
+		}
+		return sendResult(ctx, rt, result, fiber.MIMEApplicationJSONCharsetUTF8)
+	}
+}
@@ -0,0 +1,87 @@
+package manager
+
+import (
+	"bytes"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"strings"
+
+	"github.com/santhosh-tekuri/jsonschema/v5"
+
+	"github.com/yockii/ruomu-module/constant"
+	"github.com/yockii/ruomu-module/model"
+)
+
+// FieldError 字段校验错误, Field为JSON Pointer格式的字段位置
+type FieldError struct {
+	Field   string `json:"field"`
+	Message string `json:"message"`
+}
+
+// validationError 请求体校验未通过
+type validationError struct {
+	errors []*FieldError
+}
+
+func (e *validationError) Error() string {
+	var msgs []string
+	for _, fe := range e.errors {
+		msgs = append(msgs, fe.Field+": "+fe.Message)
+	}
+	return "请求参数校验失败 " + strings.Join(msgs, "; ")
+}
+
+// compileRequestSchema 编译JSON类注入点(json_post/json_put)声明的请求体Schema, 未声明时返回nil
+func compileRequestSchema(inject *model.ModuleInjectInfo) (*jsonschema.Schema, error) {
+	if inject.RequestSchema == "" || (inject.Type != constant.InjectTypeJsonPost && inject.Type != constant.InjectTypeJsonPut) {
+		return nil, nil
+	}
+	return jsonschema.CompileString(fmt.Sprintf("inject-%d.json", inject.ID), inject.RequestSchema)
+}
+
+// CheckRequestSchema 检查注入点声明的请求体Schema能否编译, 添加模块时调用
+func CheckRequestSchema(inject *model.ModuleInjectInfo) error {
+	if _, err := compileRequestSchema(inject); err != nil {
+		return fmt.Errorf("注入点%s的请求体JSON Schema编译失败: %w", inject.InjectCode, err)
+	}
+	return nil
+}
+
+// validateBody 按注入点的Schema校验请求体
+func (rt *injectRuntime) validateBody(body []byte) error {
+	if rt.requestSchema == nil {
+		return nil
+	}
+	var v interface{}
+	decoder := json.NewDecoder(bytes.NewReader(body))
+	decoder.UseNumber()
+	if err := decoder.Decode(&v); err != nil {
+		return &validationError{errors: []*FieldError{{Field: "", Message: "请求体不是有效的JSON"}}}
+	}
+	err := rt.requestSchema.Validate(v)
+	if err == nil {
+		return nil
+	}
+	var ve *jsonschema.ValidationError
+	if !errors.As(err, &ve) {
+		return err
+	}
+	result := &validationError{}
+	collectFieldErrors(ve, result)
+	return result
+}
+
+// collectFieldErrors 只收集最底层的错误, 上层错误仅为汇总信息
+func collectFieldErrors(ve *jsonschema.ValidationError, result *validationError) {
+	if len(ve.Causes) == 0 {
+		result.errors = append(result.errors, &FieldError{
+			Field:   ve.InstanceLocation,
+			Message: ve.Message,
+		})
+		return
+	}
+	for _, cause := range ve.Causes {
+		collectFieldErrors(cause, result)
+	}
+}
@@ -0,0 +1,112 @@
+package manager
+
+import (
+	"context"
+	"time"
+
+	"github.com/fasthttp/websocket"
+	"github.com/gofiber/fiber/v2"
+	"github.com/sirupsen/logrus"
+	"github.com/yockii/ruomu-core/server"
+
+	"github.com/yockii/ruomu-module/stream"
+)
+
+var wsUpgrader = websocket.FastHTTPUpgrader{
+	HandshakeTimeout: 10 * time.Second,
+}
+
+// handleWebSocket 在注入点路径上升级为WebSocket, 与模块建立双向流并双向转发消息
+// 授权校验在升级前的握手请求中完成, 浏览器无法设置请求头时可使用cookie中的token
+func (m *Manager) handleWebSocket(rt *injectRuntime) fiber.Handler {
+	return func(ctx *fiber.Ctx) error {
+		pool, has := m.modulePools[rt.moduleName]
+		if !has {
+			return ctx.JSON(&server.CommonResponse{
+				Code: server.ResponseCodeModuleNotExists,
+				Msg:  server.ResponseMsgModuleNotExists,
+			})
+		}
+		if !websocket.FastHTTPIsWebSocketUpgrade(ctx.Context()) {
+			return ctx.SendStatus(fiber.StatusUpgradeRequired)
+		}
+		if err := rt.limit.acquire(); err != nil {
+			return sendCallError(ctx, err)
+		}
+		breaker := m.moduleBreakers[rt.moduleName]
+		ticket, err := breaker.allow()
+		if err != nil {
+			rt.limit.release()
+			return sendCallError(ctx, err)
+		}
+
+		headers, value := buildCallInput(ctx, rt, paramsValue(ctx))
+		// 升级后连接脱离fiber的请求处理, 双向流的生命周期由连接双方决定
+		duplexCtx, cancel := context.WithCancel(context.Background())
+		duplex, err := pool.OpenDuplex(duplexCtx, &stream.Request{
+			Code:    rt.inject.InjectCode,
+			Headers: headers,
+			Value:   value,
+		})
+		breaker.finish(ticket, err)
+		if err != nil {
+			cancel()
+			rt.limit.release()
+			logrus.Errorln(err)
+			return sendCallError(ctx, err)
+		}
+		duplex.OnClose(cancel)
+		duplex.OnClose(rt.limit.release)
+
+		err = wsUpgrader.Upgrade(ctx.Context(), func(conn *websocket.Conn) {
+			defer func() {
+				_ = duplex.Close()
+				_ = conn.Close()
+			}()
+			relayWebSocket(conn, duplex)
+		})
+		if err != nil {
+			_ = duplex.Close()
+			logrus.Errorln(err)
+		}
+		return nil
+	}
+}
+
+// relayWebSocket 双向转发消息, 任一方断开时返回
+func relayWebSocket(conn *websocket.Conn, duplex *stream.DuplexConn) {
+	done := make(chan struct{}, 2)
+
+	// 模块 -> 客户端
+	go func() {
+		defer func() { done <- struct{}{} }()
+		for {
+			msg, err := duplex.Receive()
+			if err != nil {
+				_ = conn.WriteControl(websocket.CloseMessage,
+					websocket.FormatCloseMessage(websocket.CloseNormalClosure, ""), time.Now().Add(time.Second))
+				return
+			}
+			if err = conn.WriteMessage(msg.Type, msg.Data); err != nil {
+				return
+			}
+		}
+	}()
+
+	// 客户端 -> 模块
+	go func() {
+		defer func() { done <- struct{}{} }()
+		for {
+			messageType, data, err := conn.ReadMessage()
+			if err != nil {
+				_ = duplex.CloseSend()
+				return
+			}
+			if err = duplex.Send(&stream.Message{Type: messageType, Data: data}); err != nil {
+				return
+			}
+		}
+	}()
+
+	<-done
+}
@@ -1,12 +1,36 @@
 package model
 
 type Module struct {
-	ID         uint64 `json:"id,omitempty,string" gorm:"primaryKey;autoIncrement:false"`
-	Name       string `json:"name,omitempty" gorm:"comment:模块名称"`
-	Code       string `json:"code,omitempty" gorm:"size:50;index;comment:模块代码"`
-	Cmd        string `json:"cmd,omitempty" gorm:"size:500;comment:模块执行命令"`
-	Status     int    `json:"status,omitempty" gorm:"comment:模块状态 1-启用 -1-禁用"` // 状态 1-启用 -1-禁用
-	CreateTime int64  `json:"createTime" gorm:"autoCreateTime"`
+	ID                uint64 `json:"id,omitempty,string" gorm:"primaryKey;autoIncrement:false"`
+	Name              string `json:"name,omitempty" gorm:"comment:模块名称"`
+	Code              string `json:"code,omitempty" gorm:"size:50;index;comment:模块代码"`
+	Kind              string `json:"kind,omitempty" gorm:"size:20;comment:模块类型 plugin-go-plugin插件(默认) http-HTTP服务反向代理 remote-远程模块"`
+	Cmd               string `json:"cmd,omitempty" gorm:"size:500;comment:模块执行命令 HTTP服务类模块可为空(服务已独立运行)"`
+	ProxyTarget       string `json:"proxyTarget,omitempty" gorm:"size:500;comment:HTTP服务类模块的服务地址 如http://127.0.0.1:8081"`
+	HealthPath        string `json:"healthPath,omitempty" gorm:"size:200;comment:HTTP服务类模块的健康检查路径 默认/"`
+	RemoteAddr        string `json:"remoteAddr,omitempty" gorm:"size:500;comment:远程模块地址 host:port 多个以逗号分隔 每个地址作为一个副本"`
+	RemoteCA          string `json:"remoteCa,omitempty" gorm:"size:500;comment:远程模块CA证书文件 为空时使用系统证书"`
+	RemoteCert        string `json:"remoteCert,omitempty" gorm:"size:500;comment:连接远程模块的客户端证书文件 用于双向认证"`
+	RemoteKey         string `json:"remoteKey,omitempty" gorm:"size:500;comment:连接远程模块的客户端私钥文件"`
+	RemoteServerName  string `json:"remoteServerName,omitempty" gorm:"size:200;comment:远程模块证书的服务器名称 为空时使用地址中的主机名"`
+	RemoteInsecure    bool   `json:"remoteInsecure,omitempty" gorm:"comment:远程模块是否使用不加密连接 仅用于可信网络 需配置module.allowInsecureRemote"`
+	Status            int    `json:"status,omitempty" gorm:"comment:模块状态 1-启用 -1-禁用"` // 状态 1-启用 -1-禁用
+	Replicas          int    `json:"replicas,omitempty" gorm:"comment:模块进程副本数 默认1"`
+	Balance           string `json:"balance,omitempty" gorm:"size:20;comment:负载均衡策略 round_robin-轮询 least_in_flight-最少调用中"` // 负载均衡策略 round_robin-轮询(默认) least_in_flight-最少调用中
+	MaxConcurrent     int    `json:"maxConcurrent,omitempty" gorm:"comment:最大同时调用数 0-不限制"`
+	MaxQueue          int    `json:"maxQueue,omitempty" gorm:"comment:调用等待队列长度"`
+	QueueWait         int    `json:"queueWait,omitempty" gorm:"comment:调用排队最长等待时间(毫秒) 0-默认5000"`
+	RoutePrefix       bool   `json:"routePrefix,omitempty" gorm:"comment:HTTP注入点是否挂载在模块路由前缀/m/{code}下"`
+	ApiVersion        string `json:"apiVersion,omitempty" gorm:"size:20;comment:API版本 开启路由前缀时作为路径段 如v1"`
+	Timeout           int    `json:"timeout,omitempty" gorm:"comment:注入调用默认超时(毫秒) 0-默认30000"`
+	BreakerRatio      int    `json:"breakerRatio,omitempty" gorm:"comment:熔断失败率(百分比) 0-不启用熔断"`
+	BreakerMinCalls   int    `json:"breakerMinCalls,omitempty" gorm:"comment:熔断统计最少调用数 0-默认20"`
+	BreakerOpenTime   int    `json:"breakerOpenTime,omitempty" gorm:"comment:熔断打开时长(毫秒) 0-默认30000"`
+	RetryAttempts     int    `json:"retryAttempts,omitempty" gorm:"comment:幂等调用默认重试次数 0-不重试"`
+	RetryBackoff      int    `json:"retryBackoff,omitempty" gorm:"comment:重试初始退避时间(毫秒) 0-默认100"`
+	RetryOn           string `json:"retryOn,omitempty" gorm:"size:100;comment:可重试的错误类型 逗号分隔 unavailable,timeout,busy 默认unavailable"`
+	ForwardRawHeaders bool   `json:"forwardRawHeaders,omitempty" gorm:"comment:受信任模块 是否转发Authorization、Cookie等凭证类请求头"`
+	CreateTime        int64  `json:"createTime" gorm:"autoCreateTime"`
 }
 
 func (_ Module) TableComment() string {
@@ -26,10 +50,37 @@ func (_ ModuleDependency) TableComment() string {
 type ModuleInjectInfo struct {
 	ID                uint64 `json:"id,omitempty,string" gorm:"primaryKey;autoIncrement:false"`
 	ModuleID          uint64 `json:"moduleId,omitempty,string" gorm:"comment:模块ID"`
-	Name              string `json:"name,omitempty" gorm:"comment:注入的名称"`                                                                                                                  // 名称
-	Type              int    `json:"type,omitempty" gorm:"comment:类型 1-json_get, 2-json_post, 3-json_put, 4-json_delete, 11-html_get, 12-html_post, 13-html_put, 14-html_delete, 51-hook"` // 类型 1-json_get, 2-json_post, 3-json_put, 4-json_delete, 11-html_get, 12-html_post, 13-html_put, 14-html_delete, 51-hook
-	InjectCode        string `json:"injectCode,omitempty" gorm:"comment:注入点代码，http请求路径或定义的注入点"`                                                                                            // 注入点（http请求路径或注入点代码）
-	AuthorizationCode string `json:"authorizationCode,omitempty" gorm:"comment:授权代码 anon或空表示不需要权限 user-需要登录 其他-需要具体对应的资源权限"`                                                               // 权限代码 特殊用例：anno或空-不需要权限  user-需要登录 其他-需要具体对应的资源权限
+	Name              string `json:"name,omitempty" gorm:"comment:注入的名称"`                                                                                                                                                                         // 名称
+	Type              int    `json:"type,omitempty" gorm:"comment:类型 1-json_get, 2-json_post, 3-json_put, 4-json_delete, 11-html_get, 12-html_post, 13-html_put, 14-html_delete, 21-upload, 31-stream, 32-sse, 33-websocket, 41-static, 51-hook"` // 类型 1-json_get, 2-json_post, 3-json_put, 4-json_delete, 11-html_get, 12-html_post, 13-html_put, 14-html_delete, 21-upload, 31-stream, 32-sse, 33-websocket, 41-static, 51-hook
+	InjectCode        string `json:"injectCode,omitempty" gorm:"comment:注入点代码，http请求路径或定义的注入点"`                                                                                                                                                   // 注入点（http请求路径或注入点代码）
+	AuthorizationCode string `json:"authorizationCode,omitempty" gorm:"comment:授权代码 anon或空表示不需要权限 user-需要登录 其他-需要具体对应的资源权限"`                                                                                                                      // 权限代码 特殊用例：anno或空-不需要权限  user-需要登录 其他-需要具体对应的资源权限
+	MaxConcurrent     int    `json:"maxConcurrent,omitempty" gorm:"comment:最大同时调用数 0-不限制"`
+	MaxQueue          int    `json:"maxQueue,omitempty" gorm:"comment:调用等待队列长度"`
+	QueueWait         int    `json:"queueWait,omitempty" gorm:"comment:调用排队最长等待时间(毫秒) 0-默认5000"`
+	Timeout           int    `json:"timeout,omitempty" gorm:"comment:调用超时(毫秒) 0-使用模块默认超时"`
+	Envelope          bool   `json:"envelope,omitempty" gorm:"comment:模块返回值是否为响应包装 包含状态码、响应头、Cookie及响应体"`
+	FullRequest       bool   `json:"fullRequest,omitempty" gorm:"comment:调用参数是否使用完整请求上下文 包含请求头及请求体"`
+	Idempotent        bool   `json:"idempotent,omitempty" gorm:"comment:是否幂等 GET类注入点默认幂等 非幂等注入点不进行重试"`
+	RetryAttempts     int    `json:"retryAttempts,omitempty" gorm:"comment:重试次数 0-使用模块配置"`
+	RetryBackoff      int    `json:"retryBackoff,omitempty" gorm:"comment:重试初始退避时间(毫秒) 0-默认100"`
+	RetryOn           string `json:"retryOn,omitempty" gorm:"size:100;comment:可重试的错误类型 逗号分隔 unavailable,timeout,busy 默认unavailable"`
+	MaxBodySize       int64  `json:"maxBodySize,omitempty" gorm:"comment:请求体最大字节数 0-不限制 上传类注入点为文件总大小"`
+	MaxFileSize       int64  `json:"maxFileSize,omitempty" gorm:"comment:上传单个文件最大字节数 0-不限制"`
+	MaxFiles          int    `json:"maxFiles,omitempty" gorm:"comment:上传文件最大数量 0-不限制"`
+	AllowedTypes      string `json:"allowedTypes,omitempty" gorm:"size:500;comment:允许上传的文件类型 逗号分隔的MIME类型或扩展名 如image/*,.pdf 空-不限制"`
+	ContentTypes      string `json:"contentTypes,omitempty" gorm:"size:500;comment:允许的请求体类型 逗号分隔的MIME类型 如application/json,text/* 空-不限制"`
+	ForwardHeaders    string `json:"forwardHeaders,omitempty" gorm:"size:1000;comment:转发给模块的请求头白名单 逗号分隔 空-全部转发"`
+	BlockHeaders      string `json:"blockHeaders,omitempty" gorm:"size:1000;comment:不转发给模块的请求头 逗号分隔 优先于白名单"`
+	RequestSchema     string `json:"requestSchema,omitempty" gorm:"type:text;comment:请求体JSON Schema"`
+	ResponseSchema    string `json:"responseSchema,omitempty" gorm:"type:text;comment:响应体JSON Schema"`
+	StaticDir         string `json:"staticDir,omitempty" gorm:"size:500;comment:静态资源目录 相对路径基于模块安装目录 默认static"`
+	CacheMaxAge       int    `json:"cacheMaxAge,omitempty" gorm:"comment:静态资源缓存时间(秒) 0-每次协商"`
+	ResponseCacheTTL  int    `json:"responseCacheTTL,omitempty" gorm:"comment:GET类注入点响应缓存时间(秒) 0-不缓存"`
+	ResponseCacheVary string `json:"responseCacheVary,omitempty" gorm:"size:20;comment:响应缓存区分维度 空-所有用户共用(需要权限的注入点按用户) user-按用户 tenant-按租户"`
+	RateLimit         int    `json:"rateLimit,omitempty" gorm:"comment:限流 每个时间窗口允许的请求数 0-不限流"`
+	RateWindow        int    `json:"rateWindow,omitempty" gorm:"comment:限流时间窗口(秒) 0-默认1"`
+	RateBurst         int    `json:"rateBurst,omitempty" gorm:"comment:限流允许的额外突发请求数"`
+	RateKey           string `json:"rateKey,omitempty" gorm:"size:20;comment:限流维度 ip-按客户端IP(默认) user-按用户 tenant-按租户"`
 }
 
 func (_ ModuleInjectInfo) TableComment() string {
@@ -46,3 +97,42 @@ type ModuleSettings struct {
 func (_ ModuleSettings) TableComment() string {
 	return "模块参数配置，设置传递给模块的配置信息"
 }
+
+type ModuleHeaderPolicy struct {
+	ID                      uint64 `json:"id,omitempty,string" gorm:"primaryKey;autoIncrement:false"`
+	ModuleID                uint64 `json:"moduleId,omitempty,string" gorm:"index;comment:模块ID"`
+	InjectID                uint64 `json:"injectId,omitempty,string" gorm:"comment:注入点ID 0-模块默认策略"`
+	CorsAllowOrigins        string `json:"corsAllowOrigins,omitempty" gorm:"size:1000;comment:CORS允许的来源 逗号分隔 *-全部 空-不允许跨域"`
+	CorsAllowMethods        string `json:"corsAllowMethods,omitempty" gorm:"size:200;comment:CORS允许的请求方法 逗号分隔 空-注入点的请求方法"`
+	CorsAllowHeaders        string `json:"corsAllowHeaders,omitempty" gorm:"size:500;comment:CORS允许的请求头 逗号分隔 空-预检请求中声明的请求头"`
+	CorsExposeHeaders       string `json:"corsExposeHeaders,omitempty" gorm:"size:500;comment:CORS允许客户端读取的响应头 逗号分隔"`
+	CorsAllowCredentials    bool   `json:"corsAllowCredentials,omitempty" gorm:"comment:CORS是否允许携带凭证"`
+	CorsMaxAge              int    `json:"corsMaxAge,omitempty" gorm:"comment:CORS预检结果缓存时间(秒)"`
+	ContentSecurityPolicy   string `json:"contentSecurityPolicy,omitempty" gorm:"size:1000;comment:Content-Security-Policy响应头"`
+	FrameOptions            string `json:"frameOptions,omitempty" gorm:"size:50;comment:X-Frame-Options响应头 如DENY SAMEORIGIN"`
+	ReferrerPolicy          string `json:"referrerPolicy,omitempty" gorm:"size:100;comment:Referrer-Policy响应头"`
+	StrictTransportSecurity string `json:"strictTransportSecurity,omitempty" gorm:"size:200;comment:Strict-Transport-Security响应头"`
+	NoSniff                 bool   `json:"noSniff,omitempty" gorm:"comment:是否添加X-Content-Type-Options: nosniff"`
+	ExtraHeaders            string `json:"extraHeaders,omitempty" gorm:"type:text;comment:其他响应头 JSON对象"`
+}
+
+func (_ ModuleHeaderPolicy) TableComment() string {
+	return "模块响应头策略，包含CORS及安全相关响应头，注入点策略优先于模块默认策略"
+}
+
+type ModuleReattach struct {
+	ID              uint64 `json:"id,omitempty,string" gorm:"primaryKey;autoIncrement:false"`
+	ModuleID        uint64 `json:"moduleId,omitempty,string" gorm:"index;comment:模块ID"`
+	Replica         int    `json:"replica" gorm:"comment:进程副本序号"`
+	Cmd             string `json:"cmd,omitempty" gorm:"size:500;comment:启动进程时的模块执行命令 与当前命令不一致时不重新连接"`
+	Protocol        string `json:"protocol,omitempty" gorm:"size:20;comment:插件协议"`
+	ProtocolVersion int    `json:"protocolVersion,omitempty" gorm:"comment:插件协议版本"`
+	Network         string `json:"network,omitempty" gorm:"size:20;comment:插件监听地址类型 unix/tcp"`
+	Address         string `json:"address,omitempty" gorm:"size:500;comment:插件监听地址"`
+	Pid             int    `json:"pid,omitempty" gorm:"comment:插件进程ID"`
+	UpdateTime      int64  `json:"updateTime" gorm:"autoUpdateTime"`
+}
+
+func (_ ModuleReattach) TableComment() string {
+	return "模块插件进程的重新连接信息，主程序重启后据此连接仍在运行的插件进程"
+}
@@ -0,0 +1,112 @@
+package remote
+
+import (
+	"crypto/tls"
+	"crypto/x509"
+	"errors"
+	"net"
+	"os"
+
+	"github.com/hashicorp/go-plugin"
+	"github.com/yockii/ruomu-core/shared"
+	"google.golang.org/grpc"
+	"google.golang.org/grpc/credentials"
+	"google.golang.org/grpc/health"
+	"google.golang.org/grpc/health/grpc_health_v1"
+
+	"github.com/yockii/ruomu-module/stream"
+)
+
+// HealthService 健康检查的服务名称, 与go-plugin一致
+const HealthService = plugin.GRPCServiceName
+
+// NewServer 创建远程模块的gRPC服务, 注册通信插件、流式插件(streamer不为nil时)及健康检查
+// tlsConfig为nil时不加密, 仅用于可信网络
+func NewServer(tlsConfig *tls.Config, impl shared.Communicate, streamer stream.Streamer) (*grpc.Server, error) {
+	var opts []grpc.ServerOption
+	if tlsConfig != nil {
+		opts = append(opts, grpc.Creds(credentials.NewTLS(tlsConfig)))
+	}
+	s := grpc.NewServer(opts...)
+	if err := (&shared.CommunicatePlugin{Impl: impl}).GRPCServer(nil, s); err != nil {
+		return nil, err
+	}
+	if streamer != nil {
+		if err := (&stream.Plugin{Impl: streamer}).GRPCServer(nil, s); err != nil {
+			return nil, err
+		}
+	}
+	healthServer := health.NewServer()
+	healthServer.SetServingStatus(HealthService, grpc_health_v1.HealthCheckResponse_SERVING)
+	grpc_health_v1.RegisterHealthServer(s, healthServer)
+	return s, nil
+}
+
+// Serve 远程模块在指定地址提供服务, 阻塞直至服务停止
+func Serve(addr string, tlsConfig *tls.Config, impl shared.Communicate, streamer stream.Streamer) error {
+	s, err := NewServer(tlsConfig, impl, streamer)
+	if err != nil {
+		return err
+	}
+	lis, err := net.Listen("tcp", addr)
+	if err != nil {
+		return err
+	}
+	return s.Serve(lis)
+}
+
+func loadCertPool(caFile string) (*x509.CertPool, error) {
+	bs, err := os.ReadFile(caFile)
+	if err != nil {
+		return nil, err
+	}
+	pool := x509.NewCertPool()
+	if !pool.AppendCertsFromPEM(bs) {
+		return nil, errors.New("CA证书格式错误: " + caFile)
+	}
+	return pool, nil
+}
+
+// ClientTLSConfig 主程序连接远程模块的TLS配置, 指定证书及私钥时进行双向认证
+func ClientTLSConfig(caFile, certFile, keyFile, serverName string) (*tls.Config, error) {
+	cfg := &tls.Config{
+		MinVersion: tls.VersionTLS12,
+		ServerName: serverName,
+	}
+	if caFile != "" {
+		pool, err := loadCertPool(caFile)
+		if err != nil {
+			return nil, err
+		}
+		cfg.RootCAs = pool
+	}
+	if certFile != "" || keyFile != "" {
+		cert, err := tls.LoadX509KeyPair(certFile, keyFile)
+		if err != nil {
+			return nil, err
+		}
+		cfg.Certificates = []tls.Certificate{cert}
+	}
+	return cfg, nil
+}
+
+// ServerTLSConfig 远程模块的TLS配置, 指定CA证书时要求并校验主程序的客户端证书
+func ServerTLSConfig(caFile, certFile, keyFile string) (*tls.Config, error) {
+	cert, err := tls.LoadX509KeyPair(certFile, keyFile)
+	if err != nil {
+		return nil, err
+	}
+	cfg := &tls.Config{
+		MinVersion:   tls.VersionTLS12,
+		Certificates: []tls.Certificate{cert},
+	}
+	if caFile != "" {
+		pool, err := loadCertPool(caFile)
+		if err != nil {
+			return nil, err
+		}
+		cfg.ClientCAs = pool
+		cfg.ClientAuth = tls.RequireAndVerifyClientCert
+	}
+	return cfg, nil
+}
@@ -93,6 +93,9 @@ func (s *moduleService) Detail(id uint64) (*domain.Module, error) {
 	if err := database.DB.Where("module_id = ?", result.ID).Find(&result.Settings).Error; err != nil {
 		logger.Errorln(err)
 	}
+	if err := database.DB.Where("module_id = ?", result.ID).Find(&result.HeaderPolicies).Error; err != nil {
+		logger.Errorln(err)
+	}
 	return result, nil
 }
 
@@ -109,6 +112,16 @@ func (s *moduleService) Instance(id uint64) (*model.Module, error) {
 	return module, nil
 }
 
+// Injects 获取模块的注入点
+func (s *moduleService) Injects(moduleID uint64) ([]*model.ModuleInjectInfo, error) {
+	var injects []*model.ModuleInjectInfo
+	if err := database.DB.Where("module_id = ?", moduleID).Find(&injects).Error; err != nil {
+		logger.Errorln(err)
+		return nil, err
+	}
+	return injects, nil
+}
+
 func (s *moduleService) UpdateStatus(id uint64, status int) error {
 	if err := database.DB.Model(&model.Module{}).Where("id = ?", id).Update("status", status).Error; err != nil {
 		logger.Errorln(err)
@@ -116,3 +129,40 @@ func (s *moduleService) UpdateStatus(id uint64, status int) error {
 	}
 	return nil
 }
+
+// SaveHeaderPolicy 保存响应头策略, 同一模块的同一注入点只保留一条策略
+func (s *moduleService) SaveHeaderPolicy(policy *model.ModuleHeaderPolicy) error {
+	existing := new(model.ModuleHeaderPolicy)
+	err := database.DB.Where("module_id = ? AND inject_id = ?", policy.ModuleID, policy.InjectID).First(existing).Error
+	if err != nil && !errors.Is(err, gorm.ErrRecordNotFound) {
+		logger.Errorln(err)
+		return err
+	}
+	if err == nil {
+		policy.ID = existing.ID
+	} else {
+		policy.ID = util.SnowflakeId()
+	}
+	if err = database.DB.Save(policy).Error; err != nil {
+		logger.Errorln(err)
+		return err
+	}
+	return nil
+}
+
+// DeleteHeaderPolicy 删除响应头策略
+func (s *moduleService) DeleteHeaderPolicy(id uint64) (*model.ModuleHeaderPolicy, error) {
+	policy := new(model.ModuleHeaderPolicy)
+	if err := database.DB.Where("id = ?", id).First(policy).Error; err != nil {
+		if errors.Is(err, gorm.ErrRecordNotFound) {
+			return nil, nil
+		}
+		logger.Errorln(err)
+		return nil, err
+	}
+	if err := database.DB.Delete(policy).Error; err != nil {
+		logger.Errorln(err)
+		return nil, err
+	}
+	return policy, nil
+}
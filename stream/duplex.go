@@ -0,0 +1,159 @@
+package stream
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"io"
+	"sync"
+
+	"google.golang.org/grpc"
+	"google.golang.org/grpc/codes"
+	"google.golang.org/grpc/status"
+	"google.golang.org/protobuf/types/known/wrapperspb"
+)
+
+// 消息类型, 与WebSocket的消息类型一致
+const (
+	MessageText   = 1
+	MessageBinary = 2
+)
+
+// Message 双向流中的一条消息
+type Message struct {
+	Type int
+	Data []byte
+}
+
+// Conn 双向流连接
+type Conn interface {
+	// Receive 接收消息, 对方关闭时返回io.EOF
+	Receive() (*Message, error)
+	Send(msg *Message) error
+	// Context 对方断开或取消时结束
+	Context() context.Context
+}
+
+// DuplexStreamer 模块实现的双向流调用接口, 流式插件的Impl同时实现该接口时提供双向流调用
+type DuplexStreamer interface {
+	InjectDuplex(req *Request, conn Conn) error
+}
+
+// 双向流的第一帧为Request的JSON, 之后每帧首字节为消息类型, 其余为消息内容
+func encodeMessage(msg *Message) *wrapperspb.BytesValue {
+	bs := make([]byte, len(msg.Data)+1)
+	bs[0] = byte(msg.Type)
+	copy(bs[1:], msg.Data)
+	return wrapperspb.Bytes(bs)
+}
+
+func decodeMessage(v *wrapperspb.BytesValue) (*Message, error) {
+	bs := v.GetValue()
+	if len(bs) == 0 {
+		return nil, errors.New("消息格式错误")
+	}
+	return &Message{Type: int(bs[0]), Data: bs[1:]}, nil
+}
+
+type streamConn struct {
+	stream interface {
+		SendMsg(m any) error
+		RecvMsg(m any) error
+		Context() context.Context
+	}
+	sendLock sync.Mutex
+}
+
+func (c *streamConn) Receive() (*Message, error) {
+	in := new(wrapperspb.BytesValue)
+	if err := c.stream.RecvMsg(in); err != nil {
+		return nil, err
+	}
+	return decodeMessage(in)
+}
+
+func (c *streamConn) Send(msg *Message) error {
+	c.sendLock.Lock()
+	defer c.sendLock.Unlock()
+	return c.stream.SendMsg(encodeMessage(msg))
+}
+
+func (c *streamConn) Context() context.Context {
+	return c.stream.Context()
+}
+
+func duplexHandler(srv interface{}, ss grpc.ServerStream) error {
+	impl, ok := srv.(*server).impl.(DuplexStreamer)
+	if !ok {
+		return status.Error(codes.Unimplemented, "模块未提供双向流调用")
+	}
+	// 先发送响应头, 主程序据此确认模块提供双向流调用
+	if err := ss.SendHeader(nil); err != nil {
+		return err
+	}
+	in := new(wrapperspb.BytesValue)
+	if err := ss.RecvMsg(in); err != nil {
+		return err
+	}
+	req := new(Request)
+	if err := json.Unmarshal(in.GetValue(), req); err != nil {
+		return err
+	}
+	return impl.InjectDuplex(req, &streamConn{stream: ss})
+}
+
+// DuplexConn 主程序侧的双向流连接, 不再使用时必须Close
+type DuplexConn struct {
+	streamConn
+	cs      grpc.ClientStream
+	closers []func()
+	once    sync.Once
+}
+
+// CloseSend 通知模块不再发送消息
+func (c *DuplexConn) CloseSend() error {
+	c.sendLock.Lock()
+	defer c.sendLock.Unlock()
+	return c.cs.CloseSend()
+}
+
+// OnClose 注册关闭时执行的函数
+func (c *DuplexConn) OnClose(f func()) {
+	c.closers = append(c.closers, f)
+}
+
+func (c *DuplexConn) Close() error {
+	c.once.Do(func() {
+		for i := len(c.closers) - 1; i >= 0; i-- {
+			c.closers[i]()
+		}
+	})
+	return nil
+}
+
+// Duplex 发起双向流调用, 收到模块的响应头后返回, 模块未提供双向流调用时返回ErrUnsupported, ctx结束时调用中断
+func (c *Client) Duplex(ctx context.Context, req *Request) (*DuplexConn, error) {
+	bs, err := json.Marshal(req)
+	if err != nil {
+		return nil, err
+	}
+	cs, err := c.conn.NewStream(ctx, &serviceDesc.Streams[1], "/"+serviceName+"/Duplex")
+	if err != nil {
+		return nil, unsupported(err)
+	}
+	if err = cs.SendMsg(wrapperspb.Bytes(bs)); err != nil {
+		return nil, unsupported(err)
+	}
+	// 调用未建立时Header返回nil, 错误由RecvMsg返回
+	if md, _ := cs.Header(); md == nil {
+		err = cs.RecvMsg(new(wrapperspb.BytesValue))
+		if err == nil || errors.Is(err, io.EOF) {
+			err = errors.New("模块未建立双向流调用")
+		}
+		return nil, unsupported(err)
+	}
+	return &DuplexConn{
+		streamConn: streamConn{stream: cs},
+		cs:         cs,
+	}, nil
+}
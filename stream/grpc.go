@@ -0,0 +1,196 @@
+package stream
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"io"
+	"sync"
+
+	"google.golang.org/grpc"
+	"google.golang.org/grpc/codes"
+	"google.golang.org/grpc/status"
+	"google.golang.org/protobuf/types/known/wrapperspb"
+)
+
+// 服务未使用protoc生成, 所有消息均为BytesValue:
+// Call 请求为Request的JSON; 响应第一帧为Header的JSON, 之后每帧为一段数据
+// Duplex 见duplex.go
+const serviceName = "ruomu.module.Stream"
+
+// ErrUnsupported 模块未提供流式调用(或双向流调用)
+var ErrUnsupported = errors.New("模块未提供流式调用")
+
+// unsupported 模块未注册流式服务或未实现对应调用时gRPC返回Unimplemented, 转换为ErrUnsupported
+func unsupported(err error) error {
+	if status.Code(err) == codes.Unimplemented {
+		return ErrUnsupported
+	}
+	return err
+}
+
+type streamService interface {
+	call(req *Request, w Writer) error
+}
+
+var serviceDesc = grpc.ServiceDesc{
+	ServiceName: serviceName,
+	HandlerType: (*streamService)(nil),
+	Streams: []grpc.StreamDesc{
+		{
+			StreamName:    "Call",
+			Handler:       callHandler,
+			ServerStreams: true,
+		},
+		{
+			StreamName:    "Duplex",
+			Handler:       duplexHandler,
+			ServerStreams: true,
+			ClientStreams: true,
+		},
+	},
+	Metadata: "stream",
+}
+
+type server struct {
+	impl Streamer
+}
+
+func (s *server) call(req *Request, w Writer) error {
+	return s.impl.InjectStream(req, w)
+}
+
+func callHandler(srv interface{}, ss grpc.ServerStream) error {
+	in := new(wrapperspb.BytesValue)
+	if err := ss.RecvMsg(in); err != nil {
+		return err
+	}
+	req := new(Request)
+	if err := json.Unmarshal(in.GetValue(), req); err != nil {
+		return err
+	}
+	w := &serverWriter{ss: ss}
+	if err := srv.(streamService).call(req, w); err != nil {
+		return err
+	}
+	// 模块未写入任何数据时也需要发送头信息
+	return w.WriteHeader(&Header{})
+}
+
+type serverWriter struct {
+	ss         grpc.ServerStream
+	headerSent bool
+}
+
+func (w *serverWriter) WriteHeader(h *Header) error {
+	if w.headerSent {
+		return nil
+	}
+	bs, err := json.Marshal(h)
+	if err != nil {
+		return err
+	}
+	w.headerSent = true
+	return w.ss.SendMsg(wrapperspb.Bytes(bs))
+}
+
+func (w *serverWriter) Write(p []byte) (int, error) {
+	if err := w.WriteHeader(&Header{}); err != nil {
+		return 0, err
+	}
+	if len(p) == 0 {
+		return 0, nil
+	}
+	if err := w.ss.SendMsg(wrapperspb.Bytes(p)); err != nil {
+		return 0, err
+	}
+	return len(p), nil
+}
+
+func (w *serverWriter) Context() context.Context {
+	return w.ss.Context()
+}
+
+// Client 主程序侧的流式调用客户端
+type Client struct {
+	conn *grpc.ClientConn
+}
+
+// Call 发起流式调用并读取头信息, ctx结束时调用中断
+func (c *Client) Call(ctx context.Context, req *Request) (*Reader, error) {
+	bs, err := json.Marshal(req)
+	if err != nil {
+		return nil, err
+	}
+	cs, err := c.conn.NewStream(ctx, &serviceDesc.Streams[0], "/"+serviceName+"/Call")
+	if err != nil {
+		return nil, unsupported(err)
+	}
+	if err = cs.SendMsg(wrapperspb.Bytes(bs)); err != nil {
+		return nil, unsupported(err)
+	}
+	if err = cs.CloseSend(); err != nil {
+		return nil, err
+	}
+	first := new(wrapperspb.BytesValue)
+	if err = cs.RecvMsg(first); err != nil {
+		if errors.Is(err, io.EOF) {
+			return nil, errors.New("模块未返回流式响应头")
+		}
+		return nil, unsupported(err)
+	}
+	header := new(Header)
+	if err = json.Unmarshal(first.GetValue(), header); err != nil {
+		return nil, err
+	}
+	return &Reader{cs: cs, header: header}, nil
+}
+
+// Reader 读取流式响应数据, 读取完毕或不再需要时必须Close
+type Reader struct {
+	cs      grpc.ClientStream
+	header  *Header
+	buf     []byte
+	closers []func()
+	once    sync.Once
+}
+
+func (r *Reader) Header() *Header {
+	return r.header
+}
+
+// Next 读取下一帧数据, 结束时返回io.EOF
+func (r *Reader) Next() ([]byte, error) {
+	msg := new(wrapperspb.BytesValue)
+	if err := r.cs.RecvMsg(msg); err != nil {
+		return nil, err
+	}
+	return msg.GetValue(), nil
+}
+
+func (r *Reader) Read(p []byte) (int, error) {
+	for len(r.buf) == 0 {
+		b, err := r.Next()
+		if err != nil {
+			return 0, err
+		}
+		r.buf = b
+	}
+	n := copy(p, r.buf)
+	r.buf = r.buf[n:]
+	return n, nil
+}
+
+// OnClose 注册关闭时执行的函数
+func (r *Reader) OnClose(f func()) {
+	r.closers = append(r.closers, f)
+}
+
+func (r *Reader) Close() error {
+	r.once.Do(func() {
+		for i := len(r.closers) - 1; i >= 0; i-- {
+			r.closers[i]()
+		}
+	})
+	return nil
+}
@@ -0,0 +1,80 @@
+package stream
+
+import (
+	"context"
+	"encoding/json"
+
+	"github.com/hashicorp/go-plugin"
+	"google.golang.org/grpc"
+)
+
+// PluginSuffix 流式插件在插件集合中的名称后缀, 模块需以 模块名称+PluginSuffix 注册流式插件
+const PluginSuffix = "-stream"
+
+// PluginName 模块流式插件的名称
+func PluginName(moduleName string) string {
+	return moduleName + PluginSuffix
+}
+
+// Request 流式调用请求
+type Request struct {
+	Code    string              `json:"code"`
+	Headers map[string][]string `json:"headers,omitempty"`
+	Value   []byte              `json:"value,omitempty"`
+}
+
+// Header 流式响应的头信息, 在数据之前发送
+type Header struct {
+	Status        int               `json:"status,omitempty"`
+	ContentType   string            `json:"contentType,omitempty"`
+	ContentLength int64             `json:"contentLength,omitempty"` // <=0 表示长度未知
+	FileName      string            `json:"fileName,omitempty"`      // 不为空时作为附件下载
+	Headers       map[string]string `json:"headers,omitempty"`
+}
+
+// Writer 模块写入流式响应
+type Writer interface {
+	// WriteHeader 发送头信息, 必须在Write之前调用, 未调用时首次Write发送空的头信息
+	WriteHeader(h *Header) error
+	Write(p []byte) (int, error)
+	// Context 调用方断开或取消时结束
+	Context() context.Context
+}
+
+// Streamer 模块实现的流式调用接口
+type Streamer interface {
+	InjectStream(req *Request, w Writer) error
+}
+
+// Plugin 流式调用的go-plugin插件, 通过与通信插件相同的gRPC连接提供服务端流式调用
+type Plugin struct {
+	plugin.NetRPCUnsupportedPlugin
+	Impl Streamer
+}
+
+func (p *Plugin) GRPCServer(_ *plugin.GRPCBroker, s *grpc.Server) error {
+	s.RegisterService(&serviceDesc, &server{impl: p.Impl})
+	return nil
+}
+
+func (p *Plugin) GRPCClient(_ context.Context, _ *plugin.GRPCBroker, c *grpc.ClientConn) (interface{}, error) {
+	return &Client{conn: c}, nil
+}
+
+// Event SSE事件, SSE类注入点模块返回的每帧数据为一个Event的JSON
+type Event struct {
+	ID    string `json:"id,omitempty"`
+	Event string `json:"event,omitempty"`
+	Data  string `json:"data"`
+	Retry int    `json:"retry,omitempty"` // 客户端重连间隔(毫秒)
+}
+
+// WriteEvent 模块发送一个SSE事件
+func WriteEvent(w Writer, e *Event) error {
+	bs, err := json.Marshal(e)
+	if err != nil {
+		return err
+	}
+	_, err = w.Write(bs)
+	return err
+}